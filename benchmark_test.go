@@ -0,0 +1,95 @@
+package binstruct
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type benchLocalFileHeader struct {
+	Version           uint16
+	Flags             [2]byte
+	CompressionMethod uint16
+	FileModTime       uint16
+	FileModDate       uint16
+	Crc32             [4]byte
+	CompressedSize    uint32
+	UncompressedSize  uint32
+	FileNameLen       uint16
+	ExtraLen          uint16
+	FileName          string `bin:"len:FileNameLen"`
+	Extra             []byte `bin:"len:ExtraLen"`
+}
+
+type benchLocalFileSection struct {
+	benchLocalFileHeader
+	Body []byte `bin:"len:CompressedSize"`
+}
+
+var benchLocalFileSectionValue = benchLocalFileSection{
+	benchLocalFileHeader: benchLocalFileHeader{
+		Version:           20,
+		CompressionMethod: 8,
+		CompressedSize:    16,
+		UncompressedSize:  32,
+		FileNameLen:       8,
+		ExtraLen:          4,
+		FileName:          "file.txt",
+		Extra:             []byte{0x01, 0x02, 0x03, 0x04},
+	},
+	Body: bytes.Repeat([]byte{0xaa}, 16),
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	data, err := MarshalLE(benchLocalFileSectionValue)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var v benchLocalFileSection
+		if err := UnmarshalLE(data, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoderStream(b *testing.B) {
+	const records = 1000
+
+	var data []byte
+	w := NewWriterToBytes(&data, binary.LittleEndian, false)
+	for i := 0; i < records; i++ {
+		if err := w.Marshal(benchLocalFileSectionValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		dec := NewDecoder(bytes.NewReader(data), binary.LittleEndian)
+		for j := 0; j < records; j++ {
+			var v benchLocalFileSection
+			if err := dec.Decode(&v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := MarshalLE(benchLocalFileSectionValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -26,9 +26,10 @@ func Unmarshal(data []byte, order binary.ByteOrder, v interface{}) error {
 
 // A Decoder reads and decodes binary values from an input stream.
 type Decoder struct {
-	r     io.ReadSeeker
-	order binary.ByteOrder
-	debug bool
+	r        io.ReadSeeker
+	order    binary.ByteOrder
+	registry *Registry
+	debug    bool
 }
 
 // NewDecoder returns a new decoder that reads from r with byte order.
@@ -45,8 +46,89 @@ func (dec *Decoder) SetDebug(debug bool) {
 	dec.debug = debug
 }
 
+// SetRegistry sets the registry of decode functions, which take precedence
+// over the functions registered globally.
+func (dec *Decoder) SetRegistry(reg *Registry) {
+	dec.registry = reg
+}
+
 // Decode reads the binary-encoded value from its
 // input and stores it in the value pointed to by v.
 func (dec *Decoder) Decode(v interface{}) error {
-	return NewReader(dec.r, dec.order, dec.debug).Unmarshal(v)
+	r := &reader{
+		r:        dec.r,
+		order:    dec.order,
+		bits:     &bitReader{},
+		registry: dec.registry,
+		debug:    dec.debug,
+	}
+
+	return r.Unmarshal(v)
+}
+
+// MarshalLE returns the binary encoding of v with little-endian byte order.
+func MarshalLE(v interface{}) ([]byte, error) {
+	return Marshal(v, binary.LittleEndian)
+}
+
+// MarshalBE returns the binary encoding of v with big-endian byte order.
+func MarshalBE(v interface{}) ([]byte, error) {
+	return Marshal(v, binary.BigEndian)
+}
+
+// Marshal returns the binary encoding of v with byte order. v must be
+// a struct or a non-nil pointer to a struct, otherwise Marshal returns
+// an InvalidMarshalError. The same tags as for Unmarshal are used.
+func Marshal(v interface{}, order binary.ByteOrder) ([]byte, error) {
+	var data []byte
+	err := NewWriterToBytes(&data, order, false).Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// An Encoder writes binary values to an output stream.
+type Encoder struct {
+	w       io.Writer
+	order   binary.ByteOrder
+	written int64 // number of bytes written to w, if it isn't an io.WriteSeeker
+	debug   bool
+}
+
+// NewEncoder returns a new encoder that writes to w with byte order.
+// If w is not an io.WriteSeeker, each value is encoded into memory
+// and then written to w at once. Offsets and positions are still counted
+// from the start of the stream, but the data already written can't be
+// changed, so seeking before the start of the value is an error.
+func NewEncoder(w io.Writer, order binary.ByteOrder) *Encoder {
+	return &Encoder{
+		w:     w,
+		order: order,
+		debug: false,
+	}
+}
+
+// SetDebug if set true, all written bytes and offsets will be displayed.
+func (enc *Encoder) SetDebug(debug bool) {
+	enc.debug = debug
+}
+
+// Encode writes the binary encoding of v to the stream.
+func (enc *Encoder) Encode(v interface{}) error {
+	if ws, ok := enc.w.(io.WriteSeeker); ok {
+		return NewWriter(ws, enc.order, enc.debug).Marshal(v)
+	}
+
+	var data []byte
+	ws := &bytesWriteSeeker{buf: &data, base: enc.written}
+	err := NewWriter(ws, enc.order, enc.debug).Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	n, err := enc.w.Write(data)
+	enc.written += int64(n)
+	return err
 }
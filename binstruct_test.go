@@ -1,7 +1,12 @@
 package binstruct
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -645,6 +650,1350 @@ func Test_StringWithoutLenTag(t *testing.T) {
 	require.Equal(t, dataStruct{}, actual)
 }
 
+func Test_CString(t *testing.T) {
+	data := []byte{
+		'h', 'i', 0x00,
+		'l', 'i', 'n', 'e', '\n',
+		'a', 'b', 0x00, 0x00, 'x', 0x00,
+		'r', 'a', 'w', 0x00,
+		0xff,
+	}
+
+	type dataStruct struct {
+		Str   string `bin:"cstring"`
+		Line  string `bin:"term:0x0a"`
+		Fixed string `bin:"len:6,cstring"`
+		Raw   []byte `bin:"cstring,max:4"`
+		Next  uint8
+	}
+
+	want := dataStruct{
+		Str:   "hi",
+		Line:  "line",
+		Fixed: "ab",
+		Raw:   []byte("raw"),
+		Next:  0xff,
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+}
+
+func Test_CStringErrors(t *testing.T) {
+	type dataMax struct {
+		Str string `bin:"cstring,max:3"`
+	}
+
+	var actualMax dataMax
+	err := UnmarshalBE([]byte{'a', 'b', 'c', 0x00}, &actualMax)
+	require.EqualError(t, err, `failed set value to field "Str": binstruct: terminator not found within 3 bytes`)
+	require.True(t, errors.Is(err, ErrTerminatorNotFound))
+
+	type dataStruct struct {
+		Str string `bin:"cstring"`
+	}
+
+	var actual dataStruct
+	err = UnmarshalBE([]byte{'a', 'b'}, &actual)
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	err = UnmarshalBE([]byte{}, &actual)
+	require.True(t, errors.Is(err, io.EOF))
+
+	type dataInvalid struct {
+		Str string `bin:"term:0x100"`
+	}
+
+	var actualInvalid dataInvalid
+	err = UnmarshalBE([]byte{0x00}, &actualInvalid)
+	require.EqualError(t, err, `failed set value to field "Str": term 0x100 is greater than a byte`)
+
+	type dataSyntax struct {
+		Str string `bin:"term:zero"`
+	}
+
+	var actualSyntax dataSyntax
+	err = UnmarshalBE([]byte{0x00}, &actualSyntax)
+	require.EqualError(t, err, `failed parseTag for field "Str": invalid term "zero"`)
+}
+
+func Test_Prefix(t *testing.T) {
+	data := []byte{
+		0x02, 'h', 'i',
+		0x03, 0x00, 'y', 'a', 'y',
+		0x00, 0x00, 0x00, 0x02, 0x0a, 0x0b,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01,
+		0x80, 0x01, // uvarint 128
+	}
+	data = append(data, make([]byte, 128)...)
+	data = append(data, 0x02, 0x01, 'a', 0x01, 'b')
+
+	type dataStruct struct {
+		U8      string   `bin:"prefix:uint8"`
+		U16LE   string   `bin:"prefix:uint16,le"`
+		U32     []byte   `bin:"prefix:uint32"`
+		U64     []int16  `bin:"prefix:uint64"`
+		Uvarint []byte   `bin:"prefix:uvarint"`
+		Nested  []string `bin:"prefix:uint8,[prefix:uint8]"`
+	}
+
+	want := dataStruct{
+		U8:      "hi",
+		U16LE:   "yay",
+		U32:     []byte{0x0a, 0x0b},
+		U64:     []int16{1},
+		Uvarint: make([]byte, 128),
+		Nested:  []string{"a", "b"},
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+}
+
+func Test_PrefixErrors(t *testing.T) {
+	type dataInvalid struct {
+		Str string `bin:"prefix:int8"`
+	}
+
+	var actualInvalid dataInvalid
+	err := UnmarshalBE([]byte{0x00}, &actualInvalid)
+	require.EqualError(t, err, `failed parseTag for field "Str": invalid prefix "int8": must be uint8, uint16, uint32, uint64, uvarint, varint, zigzag or vlq`)
+
+	type dataInt struct {
+		Int uint8 `bin:"prefix:uint8"`
+	}
+
+	var actualInt dataInt
+	err = UnmarshalBE([]byte{0x00}, &actualInt)
+	require.EqualError(t, err, `failed set value to field "Int": prefix is supported only for strings and slices`)
+
+	type dataStruct struct {
+		Str string `bin:"prefix:uint16"`
+	}
+
+	var actual dataStruct
+	err = UnmarshalBE([]byte{0x00}, &actual)
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	_, err = MarshalBE(dataStruct{Str: string(make([]byte, 65536))})
+	require.EqualError(t, err, `failed write value from field "Str": write prefix: len 65536 overflows uint16`)
+}
+
+func Test_PrefixHuge(t *testing.T) {
+	// The huge count is followed by a few bytes only, it must fail without a panic or a huge allocation.
+	huge := []byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x02, 0x03}
+
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{
+			name: "bytes",
+			value: &struct {
+				Data []byte `bin:"prefix:uint64"`
+			}{},
+		},
+		{
+			name: "string",
+			value: &struct {
+				Data string `bin:"prefix:uint64"`
+			}{},
+		},
+		{
+			name: "uint16 slice",
+			value: &struct {
+				Data []uint16 `bin:"prefix:uint64"`
+			}{},
+		},
+		{
+			name: "uint64 slice",
+			value: &struct {
+				Data []uint64 `bin:"prefix:uint64"`
+			}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := UnmarshalBE(huge, tt.value)
+			require.True(t, errors.Is(err, io.ErrUnexpectedEOF), err)
+		})
+	}
+
+	type uvarintStruct struct {
+		Data []int32 `bin:"prefix:uvarint"`
+	}
+
+	var actual uvarintStruct
+	err := UnmarshalBE([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0x01, 0x02}, &actual)
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF), err)
+}
+
+func Test_Rest(t *testing.T) {
+	type entry struct {
+		ID    uint8
+		Value uint16
+	}
+
+	type dataStruct struct {
+		Count   uint8
+		Entries []entry `bin:"len:*"`
+	}
+
+	data := []byte{0x02, 0x01, 0x00, 0x0a, 0x02, 0x00, 0x0b}
+
+	want := dataStruct{
+		Count:   2,
+		Entries: []entry{{ID: 1, Value: 0x0a}, {ID: 2, Value: 0x0b}},
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+
+	err = UnmarshalBE([]byte{0x00}, &actual)
+	require.NoError(t, err)
+	require.Equal(t, dataStruct{Entries: []entry{}}, actual)
+
+	err = UnmarshalBE(data[:5], &actual)
+	require.EqualError(t, err, `failed set value to field "Entries": element 1: unexpected EOF`)
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+func Test_RestBytesAndString(t *testing.T) {
+	type dataStruct struct {
+		Header [2]byte
+		Str    string `bin:"len:2"`
+		Data   []byte `bin:"rest"`
+	}
+
+	data := []byte{0x01, 0x02, 'h', 'i', 0x03, 0x04, 0x05}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, dataStruct{Header: [2]byte{0x01, 0x02}, Str: "hi", Data: []byte{0x03, 0x04, 0x05}}, actual)
+
+	type dataString struct {
+		Str string `bin:"rest"`
+	}
+
+	var actualString dataString
+	err = UnmarshalBE(data, &actualString)
+	require.NoError(t, err)
+	require.Equal(t, string(data), actualString.Str)
+
+	type dataInt struct {
+		Int uint8 `bin:"rest"`
+	}
+
+	var actualInt dataInt
+	err = UnmarshalBE(data, &actualInt)
+	require.EqualError(t, err, `failed set value to field "Int": rest is supported only for strings and slices`)
+}
+
+func Test_SentinelTerminated(t *testing.T) {
+	type chunk struct {
+		Type uint8
+		Size uint8
+	}
+
+	type dataStruct struct {
+		Values  []uint16 `bin:"term:0xFFFF"`
+		Signed  []int16  `bin:"term:-1,keepTerm"`
+		Chunks  []chunk  `bin:"until:Type==0"`
+		Limited []uint16 `bin:"len:2,term:0"`
+		Next    uint8
+	}
+
+	data := []byte{
+		0x00, 0x01, 0x00, 0x02, 0xff, 0xff,
+		0x00, 0x03, 0xff, 0xff,
+		0x01, 0x0a, 0x02, 0x0b, 0x00, 0x00,
+		0x00, 0x05, 0x00, 0x06,
+		0x07,
+	}
+
+	want := dataStruct{
+		Values:  []uint16{1, 2},
+		Signed:  []int16{3, -1},
+		Chunks:  []chunk{{Type: 1, Size: 0x0a}, {Type: 2, Size: 0x0b}},
+		Limited: []uint16{5, 6},
+		Next:    7,
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+
+	err = UnmarshalBE([]byte{0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0x07}, &actual)
+	require.NoError(t, err)
+	require.Equal(t, dataStruct{
+		Values:  []uint16{},
+		Signed:  []int16{-1},
+		Chunks:  []chunk{},
+		Limited: []uint16{},
+		Next:    7,
+	}, actual)
+
+	_, err = MarshalBE(dataStruct{Values: []uint16{1, 0xffff, 2}})
+	require.EqualError(t, err, `failed write value from field "Values": element 1 matches the sentinel`)
+}
+
+func Test_SentinelTerminatedUntilExpression(t *testing.T) {
+	type chunk struct {
+		Type  uint8
+		Flags uint8
+	}
+
+	type dataStruct struct {
+		Last   uint8
+		Chunks []chunk `bin:"until:Flags&0x80 != 0 || Type >= ../Last,keepTerm"`
+		Marked []chunk `bin:"until:Type == 0xff"`
+	}
+
+	data := []byte{
+		0x05,
+		0x01, 0x00, 0x02, 0x81,
+		0x01, 0x00, 0xff, 0x00,
+	}
+
+	want := dataStruct{
+		Last:   5,
+		Chunks: []chunk{{Type: 1}, {Type: 2, Flags: 0x81}},
+		Marked: []chunk{{Type: 1}},
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+
+	err = UnmarshalBE([]byte{0x02, 0x01, 0x00, 0x02, 0x00, 0xff, 0x00}, &actual)
+	require.NoError(t, err)
+	require.Equal(t, dataStruct{
+		Last:   2,
+		Chunks: []chunk{{Type: 1}, {Type: 2}},
+		Marked: []chunk{},
+	}, actual)
+
+	type dataOverflow struct {
+		Chunks []chunk `bin:"until:Type == 0x100"`
+	}
+
+	_, err = MarshalBE(dataOverflow{})
+	require.EqualError(t, err, `failed write value from field "Chunks": cannot make the sentinel element for until "Type == 0x100", use keepTerm`)
+}
+
+func Test_SentinelTerminatedMaxFromField(t *testing.T) {
+	type dataStruct struct {
+		Max   uint8
+		Items []uint16 `bin:"len:Max,term:0"`
+	}
+
+	// The len field is the max number of elements, it is not filled from the actual length.
+	v := dataStruct{Max: 10, Items: []uint16{1, 2}}
+
+	data, err := MarshalBE(v)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x0a, 0x00, 0x01, 0x00, 0x02, 0x00, 0x00}, data)
+
+	var actual dataStruct
+	err = UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, v, actual)
+
+	type dataNegative struct {
+		Max   int8
+		Items []uint16 `bin:"len:Max,term:0"`
+	}
+
+	var actualNegative dataNegative
+	err = UnmarshalBE([]byte{0xfe, 0x00, 0x01, 0x00, 0x00}, &actualNegative)
+	require.EqualError(t, err, `failed set value to field "Items": binstruct: negative count`)
+}
+
+func Test_SentinelTerminatedErrors(t *testing.T) {
+	type dataUntil struct {
+		Values []uint16 `bin:"until:Type==0"`
+	}
+
+	var actualUntil dataUntil
+	err := UnmarshalBE([]byte{0x00, 0x00}, &actualUntil)
+	require.EqualError(t, err, `failed set value to field "Values": until is supported only for slices of structs`)
+
+	type dataTerm struct {
+		Values []float32 `bin:"term:0"`
+	}
+
+	var actualTerm dataTerm
+	err = UnmarshalBE([]byte{0x00, 0x00, 0x00, 0x00}, &actualTerm)
+	require.EqualError(t, err, `failed set value to field "Values": term is supported only for slices of integers`)
+
+	type chunk struct {
+		Type uint8
+	}
+
+	type dataSyntax struct {
+		Chunks []chunk `bin:"until:Type=>0"`
+	}
+
+	var actualSyntax dataSyntax
+	err = UnmarshalBE([]byte{0x00}, &actualSyntax)
+	require.EqualError(t, err, `failed parseTag for field "Chunks": invalid until: invalid expression "Type=>0": unexpected "=" at 4`)
+
+	type dataUnknown struct {
+		Chunks []chunk `bin:"until:Kind==0"`
+	}
+
+	var actualUnknown dataUnknown
+	err = UnmarshalBE([]byte{0x00}, &actualUnknown)
+	require.EqualError(t, err, `failed set value to field "Chunks": element 0: until: can't get field len from "Kind" field`)
+
+	type dataNotEqual struct {
+		Chunks []chunk `bin:"until:Type!=1"`
+	}
+
+	type dataArray struct {
+		Values [4]uint16 `bin:"term:0"`
+	}
+
+	var actualArray dataArray
+	err = UnmarshalBE([]byte{0x00, 0x01, 0x00, 0x00}, &actualArray)
+	require.EqualError(t, err, `failed parseTag for field "Values": term, until and keepTerm are not supported for arrays, use a slice`)
+
+	_, err = MarshalBE(dataArray{})
+	require.EqualError(t, err, `failed parseTag for field "Values": term, until and keepTerm are not supported for arrays, use a slice`)
+
+	_, err = MarshalBE(dataNotEqual{})
+	require.EqualError(t, err, `failed write value from field "Chunks": cannot make the sentinel element for until "Type!=1", use keepTerm`)
+}
+
+func Test_Size(t *testing.T) {
+	type entry struct {
+		ID    uint8
+		Value uint16
+	}
+
+	type header struct {
+		Version uint8
+	}
+
+	type dataStruct struct {
+		EntriesSize uint8
+		Entries     []entry `bin:"size:EntriesSize"`
+		Header      header  `bin:"size:4"`
+		Name        string  `bin:"size:4,cstring"`
+		Label       string  `bin:"size:4"` // the zero padding is trimmed
+		Tail        uint8
+	}
+
+	data := []byte{
+		0x06, 0x01, 0x00, 0x0a, 0x02, 0x00, 0x0b, // entries
+		0x03, 0xff, 0xff, 0xff, // header with unread rest
+		'h', 'i', 0x00, 0xff, // name
+		'a', 'b', 0x00, 0x00, // label
+		0x07,
+	}
+
+	want := dataStruct{
+		EntriesSize: 6,
+		Entries:     []entry{{ID: 1, Value: 0x0a}, {ID: 2, Value: 0x0b}},
+		Header:      header{Version: 3},
+		Name:        "hi",
+		Label:       "ab",
+		Tail:        7,
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	// The rest of the region is padded with zeros on write.
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0x06, 0x01, 0x00, 0x0a, 0x02, 0x00, 0x0b,
+		0x03, 0x00, 0x00, 0x00,
+		'h', 'i', 0x00, 0x00,
+		'a', 'b', 0x00, 0x00,
+		0x07,
+	}, encoded)
+
+	err = UnmarshalBE(encoded, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+}
+
+func Test_SizeErrors(t *testing.T) {
+	type entry struct {
+		ID    uint8
+		Value uint16
+	}
+
+	type dataEntries struct {
+		Entries []entry `bin:"size:4"`
+	}
+
+	var actualEntries dataEntries
+	err := UnmarshalBE([]byte{0x01, 0x00, 0x0a, 0x02, 0x00, 0x0b}, &actualEntries)
+	require.EqualError(t, err, `failed set value to field "Entries": element 1: unexpected EOF`)
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	type header struct {
+		Version uint8
+		Flags   uint16
+	}
+
+	type dataHeader struct {
+		Header header `bin:"size:2"`
+	}
+
+	var actualHeader dataHeader
+	err = UnmarshalBE([]byte{0x01, 0x00, 0x00, 0x00}, &actualHeader)
+	require.EqualError(t, err, `failed set value to field "Header": unmarshal struct: failed set value to field "Flags": unexpected EOF`)
+
+	type dataNegative struct {
+		Size int8
+		Str  string `bin:"size:Size"`
+	}
+
+	var actualNegative dataNegative
+	err = UnmarshalBE([]byte{0xff}, &actualNegative)
+	require.EqualError(t, err, `failed set value to field "Str": binstruct: negative count`)
+
+	_, err = MarshalBE(dataHeader{Header: header{Version: 1}})
+	require.EqualError(t, err, `failed write value from field "Header": written 3 bytes, greater than size 2`)
+}
+
+func Test_Bits(t *testing.T) {
+	type dataStruct struct {
+		Version uint8 `bin:"bits:3"`
+		_       uint8 `bin:"bits:1"`
+		Count   uint8 `bin:"bits:4"`
+		Length  uint16
+		Width   uint16 `bin:"bits:12"`
+		Delta   int8   `bin:"bits:4"`
+		Enabled bool   `bin:"bits:1"`
+		Tail    uint8
+	}
+
+	data := []byte{
+		0b101_1_0110,
+		0x00, 0x10,
+		0xab, 0xcf,
+		0b1_0000000, // Enabled, the rest is skipped
+		0x07,
+	}
+
+	want := dataStruct{
+		Version: 5,
+		Count:   6,
+		Length:  0x10,
+		Width:   0xabc,
+		Delta:   -1,
+		Enabled: true,
+		Tail:    7,
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	// Skipped bits are written as zeros.
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0b101_0_0110, 0x00, 0x10, 0xab, 0xcf, 0b1_0000000, 0x07}, encoded)
+}
+
+func Test_BitsLSB(t *testing.T) {
+	// The order is set for the whole struct, the blank field doesn't start a new byte.
+	type dataStruct struct {
+		Low  uint8    `bin:"bits:3"`
+		Mid  uint8    `bin:"bits:3"`
+		_    struct{} `bin:"lsb"`
+		High uint8    `bin:"bits:4"`
+		Rest uint8    `bin:"bits:6"`
+		Flag uint8    `bin:"bits:8,msb"`
+	}
+
+	data := []byte{0b01_110_101, 0b101101_10, 0xa4}
+
+	want := dataStruct{Low: 5, Mid: 6, High: 0b1001, Rest: 0b101101, Flag: 0xa4}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+}
+
+func Test_BitsErrors(t *testing.T) {
+	type dataOverflow struct {
+		Value uint8 `bin:"bits:9"`
+	}
+
+	var actualOverflow dataOverflow
+	err := UnmarshalBE([]byte{0x00, 0x00}, &actualOverflow)
+	require.EqualError(t, err, `failed set value to field "Value": bits 9 is out of range 1..8 for uint8`)
+
+	_, err = MarshalBE(struct {
+		Value uint8 `bin:"bits:3"`
+	}{Value: 8})
+	require.EqualError(t, err, `failed write value from field "Value": value 8 overflows 3 bits`)
+
+	type dataEOF struct {
+		A uint8  `bin:"bits:4"`
+		B uint16 `bin:"bits:12"`
+	}
+
+	var actualEOF dataEOF
+	err = UnmarshalBE([]byte{0x12}, &actualEOF)
+	require.EqualError(t, err, `failed set value to field "B": unexpected EOF`)
+
+	type dataString struct {
+		Str string `bin:"bits:4"`
+	}
+
+	var actualString dataString
+	err = UnmarshalBE([]byte{0x12}, &actualString)
+	require.EqualError(t, err, `failed set value to field "Str": bits is supported only for integers and bools`)
+
+	type dataTags struct {
+		Value uint16 `bin:"bits:4,le"`
+	}
+
+	var actualTags dataTags
+	err = UnmarshalBE([]byte{0x12}, &actualTags)
+	require.EqualError(t, err, `failed parseTag for field "Value": bits can't be used with other tags, except lsb and msb`)
+
+	type dataMixed struct {
+		A uint8 `bin:"bits:4"`
+		B uint8 `bin:"bits:4,lsb"`
+	}
+
+	var actualMixed dataMixed
+	err = UnmarshalBE([]byte{0x12}, &actualMixed)
+	require.EqualError(t, err, `failed set value to field "B": bit order changed in the middle of a byte`)
+
+	type dataByteOrder struct {
+		Value uint8 `bin:"lsb"`
+		A     uint8 `bin:"bits:4"`
+	}
+
+	var actualByteOrder dataByteOrder
+	err = UnmarshalBE([]byte{0x12, 0x34}, &actualByteOrder)
+	require.EqualError(t, err, `failed parseTag for field "Value": lsb and msb are supported only for bit fields, `+
+		`use the blank struct{} field to set the bit order of the struct`)
+
+	type dataElemOrder struct {
+		Values []uint8 `bin:"len:2,[lsb]"`
+	}
+
+	var actualElemOrder dataElemOrder
+	err = UnmarshalBE([]byte{0x12, 0x34}, &actualElemOrder)
+	require.EqualError(t, err, `failed parseTag for field "Values": lsb and msb are supported only for bit fields`)
+
+	type dataConflict struct {
+		_ struct{} `bin:"lsb"`
+		A uint8    `bin:"bits:4"`
+		_ struct{} `bin:"msb"`
+	}
+
+	var actualConflict dataConflict
+	err = UnmarshalBE([]byte{0x12}, &actualConflict)
+	require.EqualError(t, err, `failed parseTag for field "_": bit order of the struct is already set to lsb`)
+}
+
+func Test_Varint(t *testing.T) {
+	type dataStruct struct {
+		ID     uint32   `bin:"uvarint"`
+		Offset int64    `bin:"varint"`
+		Delta  int16    `bin:"zigzag"`
+		Delay  uint32   `bin:"vlq"`
+		Values []uint64 `bin:"len:2,[uvarint]"`
+		Name   string   `bin:"prefix:uvarint"`
+		Tags   []int8   `bin:"prefix:vlq,[zigzag]"`
+	}
+
+	data := []byte{
+		0xe5, 0x8e, 0x26,
+		0xc0, 0xbb, 0x78,
+		0x03,
+		0x81, 0x00,
+		0x01, 0xac, 0x02,
+		0x02, 'h', 'i',
+		0x02, 0x01, 0x02,
+	}
+
+	want := dataStruct{
+		ID:     624485,
+		Offset: -123456,
+		Delta:  -2,
+		Delay:  0x80,
+		Values: []uint64{1, 300},
+		Name:   "hi",
+		Tags:   []int8{-1, 1},
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+}
+
+func Test_VarintErrors(t *testing.T) {
+	type dataOverflow struct {
+		Value uint8 `bin:"uvarint"`
+	}
+
+	var actualOverflow dataOverflow
+	err := UnmarshalBE([]byte{0xac, 0x02}, &actualOverflow)
+	require.EqualError(t, err, `failed set value to field "Value": value 300 overflows uint8`)
+
+	type dataNegative struct {
+		Value uint16 `bin:"varint"`
+	}
+
+	var actualNegative dataNegative
+	err = UnmarshalBE([]byte{0x7f}, &actualNegative)
+	require.EqualError(t, err, `failed set value to field "Value": value -1 overflows uint16`)
+
+	_, err = MarshalBE(struct {
+		Value int32 `bin:"uvarint"`
+	}{Value: -1})
+	require.EqualError(t, err, `failed write value from field "Value": value -1 overflows uvarint`)
+
+	type dataString struct {
+		Str string `bin:"zigzag"`
+	}
+
+	var actualString dataString
+	err = UnmarshalBE([]byte{0x01}, &actualString)
+	require.EqualError(t, err, `failed set value to field "Str": zigzag is supported only for integers`)
+
+	type dataLen struct {
+		Value uint32 `bin:"vlq,len:2"`
+	}
+
+	var actualLen dataLen
+	err = UnmarshalBE([]byte{0x01}, &actualLen)
+	require.EqualError(t, err, `failed parseTag for field "Value": vlq can't be used with len`)
+
+	type dataEOF struct {
+		Value uint32 `bin:"uvarint"`
+	}
+
+	var actualEOF dataEOF
+	err = UnmarshalBE([]byte{0x80}, &actualEOF)
+	require.EqualError(t, err, `failed set value to field "Value": unexpected EOF`)
+}
+
+func Test_If(t *testing.T) {
+	type dataStruct struct {
+		Version    uint8
+		Flags      uint16
+		HasComment bool
+		Extra      uint32 `bin:"if:Version>=2"`
+		Descriptor uint32 `bin:"if:Flags&0x08!=0"`
+		Comment    string `bin:"if:HasComment,len:2"`
+		Tail       uint8
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want dataStruct
+	}{
+		{
+			name: "all",
+			data: []byte{0x02, 0x00, 0x08, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 'h', 'i', 0x07},
+			want: dataStruct{
+				Version: 2, Flags: 0x08, HasComment: true,
+				Extra: 1, Descriptor: 2, Comment: "hi", Tail: 7,
+			},
+		},
+		{
+			name: "none",
+			data: []byte{0x01, 0x00, 0x07, 0x00, 0x07},
+			want: dataStruct{Version: 1, Flags: 0x07, Tail: 7},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Skipped fields are set to the zero value.
+			actual := dataStruct{Extra: 42}
+			err := UnmarshalBE(tt.data, &actual)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, actual)
+
+			encoded, err := MarshalBE(tt.want)
+			require.NoError(t, err)
+			require.Equal(t, tt.data, encoded)
+		})
+	}
+}
+
+func Test_IfErrors(t *testing.T) {
+	type dataInvalid struct {
+		Value uint8 `bin:"if:Version=>2"`
+	}
+
+	var actualInvalid dataInvalid
+	err := UnmarshalBE([]byte{0x01}, &actualInvalid)
+	require.EqualError(t, err, `failed parseTag for field "Value": invalid expression "Version=>2": unexpected "=" at 7`)
+
+	type dataUnknown struct {
+		Value uint8 `bin:"if:Version>=2"`
+	}
+
+	var actualUnknown dataUnknown
+	err = UnmarshalBE([]byte{0x01}, &actualUnknown)
+	require.EqualError(t, err, `failed parse ReadData from tags for field "Value": can't get field len from "Version" field`)
+}
+
+func Test_Pointers(t *testing.T) {
+	type header struct {
+		Version uint8
+		NameLen uint8
+	}
+
+	type extra struct {
+		Value uint16 `bin:"le"`
+	}
+
+	type dataStruct struct {
+		Header *header
+		Name   *string   `bin:"len:Header.NameLen"`
+		Values []*uint16 `bin:"len:2"`
+		Flag   **bool
+		Extra  *extra `bin:"if:Header.Version >= 2"`
+		Tail   *uint8 `bin:"offset:1"`
+	}
+
+	name := "hi"
+	values := []uint16{1, 2}
+	flag := true
+	flagPtr := &flag
+	tail := uint8(7)
+
+	tests := []struct {
+		name string
+		data []byte
+		want dataStruct
+	}{
+		{
+			name: "with extra",
+			data: []byte{0x02, 0x02, 'h', 'i', 0x00, 0x01, 0x00, 0x02, 0x01, 0x03, 0x00, 0x00, 0x07},
+			want: dataStruct{
+				Header: &header{Version: 2, NameLen: 2},
+				Name:   &name,
+				Values: []*uint16{&values[0], &values[1]},
+				Flag:   &flagPtr,
+				Extra:  &extra{Value: 3},
+				Tail:   &tail,
+			},
+		},
+		{
+			name: "without extra",
+			data: []byte{0x01, 0x02, 'h', 'i', 0x00, 0x01, 0x00, 0x02, 0x01, 0x00, 0x07},
+			want: dataStruct{
+				Header: &header{Version: 1, NameLen: 2},
+				Name:   &name,
+				Values: []*uint16{&values[0], &values[1]},
+				Flag:   &flagPtr,
+				Tail:   &tail,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Skipped pointer fields are set to nil.
+			actual := dataStruct{Extra: &extra{Value: 42}}
+			err := UnmarshalBE(tt.data, &actual)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, actual)
+
+			encoded, err := MarshalBE(tt.want)
+			require.NoError(t, err)
+			require.Equal(t, tt.data, encoded)
+		})
+	}
+}
+
+func Test_PointersErrors(t *testing.T) {
+	type header struct {
+		Len uint8
+	}
+
+	type dataNil struct {
+		Header *header
+		Value  *uint8
+	}
+
+	_, err := MarshalBE(dataNil{Header: &header{}})
+	require.EqualError(t, err, `failed write value from field "Value": nil pointer, use the "if" tag for optional fields`)
+
+	type dataNilField struct {
+		Header *header `bin:"-"`
+		Data   []byte  `bin:"len:Header.Len"`
+	}
+
+	var actual dataNilField
+	err = UnmarshalBE([]byte{0x01}, &actual)
+	require.EqualError(t, err, `failed parse ReadData from tags for field "Data": `+
+		`can't get field "Header.Len": nil pointer`)
+}
+
+func Test_Map(t *testing.T) {
+	type entry struct {
+		Size  uint8
+		Value []byte `bin:"len:Size"`
+	}
+
+	type dataStruct struct {
+		Count   uint8
+		Names   map[string]string `bin:"len:Count, [key:cstring][val:prefix:uint8]"`
+		Entries map[[2]byte]entry `bin:"len:2"`
+		IDs     map[uint16]bool   `bin:"len:Count-1, [key:le]"`
+	}
+
+	data := []byte{
+		0x02,
+		'f', 'a', 'm', 'i', 'l', 'y', 0x00, 0x04, 'G', 'o', 'N', 'o',
+		'n', 'a', 'm', 'e', 0x00, 0x03, 'M', 'o', 'n',
+		'a', 'a', 0x01, 0xff,
+		'a', 'b', 0x00,
+		0x01, 0x00, 0x01,
+	}
+
+	want := dataStruct{
+		Count: 2,
+		Names: map[string]string{"family": "GoNo", "name": "Mon"},
+		Entries: map[[2]byte]entry{
+			{'a', 'a'}: {Size: 1, Value: []byte{0xff}},
+			{'a', 'b'}: {Size: 0, Value: []byte{}},
+		},
+		IDs: map[uint16]bool{1: true},
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	// The count is filled from the map, the keys are written in order.
+	want.Count = 0
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+}
+
+func Test_MapDupKey(t *testing.T) {
+	data := []byte{0x03, 0x01, 0x0a, 0x02, 0x0b, 0x01, 0x0c}
+
+	type dataError struct {
+		Count uint8
+		Map   map[uint8]uint8 `bin:"len:Count"`
+	}
+
+	var actualError dataError
+	err := UnmarshalBE(data, &actualError)
+	require.EqualError(t, err, `failed set value to field "Map": duplicate key 1`)
+
+	type dataFirst struct {
+		Count uint8
+		Map   map[uint8]uint8 `bin:"len:Count, dupKey:first"`
+	}
+
+	var actualFirst dataFirst
+	err = UnmarshalBE(data, &actualFirst)
+	require.NoError(t, err)
+	require.Equal(t, dataFirst{Count: 3, Map: map[uint8]uint8{1: 0x0a, 2: 0x0b}}, actualFirst)
+
+	type dataLast struct {
+		Count uint8
+		Map   map[uint8]uint8 `bin:"len:Count, dupKey:last"`
+	}
+
+	var actualLast dataLast
+	err = UnmarshalBE(data, &actualLast)
+	require.NoError(t, err)
+	require.Equal(t, dataLast{Count: 3, Map: map[uint8]uint8{1: 0x0c, 2: 0x0b}}, actualLast)
+}
+
+func Test_MapErrors(t *testing.T) {
+	type dataNoLen struct {
+		Map map[uint8]uint8
+	}
+
+	var actualNoLen dataNoLen
+	err := UnmarshalBE([]byte{0x01, 0x02}, &actualNoLen)
+	require.EqualError(t, err, `failed set value to field "Map": need set tag with len for map`)
+
+	type dataDupKey struct {
+		Map map[uint8]uint8 `bin:"len:1, dupKey:any"`
+	}
+
+	var actualDupKey dataDupKey
+	err = UnmarshalBE([]byte{0x01, 0x02}, &actualDupKey)
+	require.EqualError(t, err, `failed parseTag for field "Map": invalid dupKey "any": must be error, first or last`)
+
+	type dataValue struct {
+		Map map[uint8]string `bin:"len:1"`
+	}
+
+	var actualValue dataValue
+	err = UnmarshalBE([]byte{0x01, 0x02}, &actualValue)
+	require.EqualError(t, err, `failed set value to field "Map": value 0: need set tag with len for string`)
+
+	// The huge count is not preallocated.
+	type dataHuge struct {
+		Count uint32
+		Map   map[uint32]uint32 `bin:"len:Count"`
+	}
+
+	var actualHuge dataHuge
+	err = UnmarshalBE([]byte{0xff, 0xff, 0xff, 0xff, 0x00}, &actualHuge)
+	require.EqualError(t, err, `failed set value to field "Map": key 0: unexpected EOF`)
+
+	_, err = MarshalBE(struct {
+		Map map[uint8]uint8 `bin:"len:2"`
+	}{Map: map[uint8]uint8{1: 2}})
+	require.EqualError(t, err, `failed write value from field "Map": expected len 2, got 1`)
+}
+
+func Test_ParentFields(t *testing.T) {
+	type entry struct {
+		Name  string `bin:"len:$root.Header.NameLen"`
+		Extra []byte `bin:"if:$parent.Version >= 2, len:../../Header.NameLen - 1"`
+	}
+
+	type section struct {
+		Version uint8
+		Entries []entry `bin:"len:../Header.Count"`
+	}
+
+	type dataStruct struct {
+		Header struct {
+			Count   uint8
+			NameLen uint8
+		}
+		Section section
+	}
+
+	data := []byte{
+		0x02, 0x02, // Header
+		0x02,                           // Version
+		'a', 'b', 0x01, 'c', 'd', 0x02, // Entries
+	}
+
+	want := dataStruct{
+		Section: section{
+			Version: 2,
+			Entries: []entry{{Name: "ab", Extra: []byte{0x01}}, {Name: "cd", Extra: []byte{0x02}}},
+		},
+	}
+	want.Header.Count = 2
+	want.Header.NameLen = 2
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+
+	// Fields of parent structs are already written, so they are not filled.
+	want.Header.Count = 3
+	_, err = MarshalBE(want)
+	require.EqualError(t, err, `failed write value from field "Section": marshal struct: `+
+		`failed fill len for field "Entries": len field "../Header.Count" of the parent struct must be 2`)
+}
+
+func Test_ParentFieldsErrors(t *testing.T) {
+	type dataNoParent struct {
+		Value []byte `bin:"len:../Count"`
+	}
+
+	var actualNoParent dataNoParent
+	err := UnmarshalBE([]byte{0x01}, &actualNoParent)
+	require.EqualError(t, err, `failed parse ReadData from tags for field "Value": can't get field "../Count": no parent struct`)
+
+	type dataUnknownVariable struct {
+		Value []byte `bin:"len:$self.Count"`
+	}
+
+	var actualUnknownVariable dataUnknownVariable
+	err = UnmarshalBE([]byte{0x01}, &actualUnknownVariable)
+	require.EqualError(t, err, `failed parseTag for field "Value": invalid expression "$self.Count": unknown variable "$self" at 0`)
+}
+
+func Test_ElemIndex(t *testing.T) {
+	type header struct {
+		Size uint8
+	}
+
+	type dataStruct struct {
+		Count   uint8
+		Sizes   []uint8  `bin:"len:Count"`
+		Blobs   [][]byte `bin:"len:Count, [len:Sizes[$i]]"`
+		Headers [2]header
+		Names   [2]string `bin:"[len:Headers[$index].Size]"`
+		Flags   [3]uint8  `bin:"[if:$i != 1]"`
+	}
+
+	data := []byte{
+		0x02,       // Count
+		0x01, 0x02, // Sizes
+		'a', 'b', 'c', // Blobs
+		0x01, 0x02, // Headers
+		'x', 'y', 'z', // Names
+		0x07, 0x09, // Flags
+	}
+
+	want := dataStruct{
+		Count:   2,
+		Sizes:   []uint8{1, 2},
+		Blobs:   [][]byte{[]byte("a"), []byte("bc")},
+		Headers: [2]header{{Size: 1}, {Size: 2}},
+		Names:   [2]string{"x", "yz"},
+		Flags:   [3]uint8{7, 0, 9},
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+}
+
+func Test_ElemIndexErrors(t *testing.T) {
+	type dataOutOfRange struct {
+		Sizes []uint8  `bin:"len:1"`
+		Blobs [][]byte `bin:"len:2, [len:Sizes[$i]]"`
+	}
+
+	var actualOutOfRange dataOutOfRange
+	err := UnmarshalBE([]byte{0x01, 'a', 'b'}, &actualOutOfRange)
+	require.EqualError(t, err, `failed set value to field "Blobs": element 1: index 1 out of range [0:1] in "Sizes[$i]"`)
+
+	type dataNotElem struct {
+		Value []byte `bin:"len:$i"`
+	}
+
+	var actualNotElem dataNotElem
+	err = UnmarshalBE([]byte{0x01}, &actualNotElem)
+	require.EqualError(t, err, `failed parse ReadData from tags for field "Value": "$i" is available only in element tags`)
+
+	type dataNotIndexable struct {
+		Size  uint8
+		Value []byte `bin:"len:Size[0]"`
+	}
+
+	var actualNotIndexable dataNotIndexable
+	err = UnmarshalBE([]byte{0x01}, &actualNotIndexable)
+	require.EqualError(t, err, `failed parse ReadData from tags for field "Value": can't index uint8 in "Size[0]"`)
+}
+
+func Test_Funcs(t *testing.T) {
+	type header struct {
+		Magic   [4]byte
+		Version uint16
+		Flags   uint8 `bin:"bits:3"`
+		Kind    uint8 `bin:"bits:5"`
+	}
+
+	type dataStruct struct {
+		Size   uint16 // size of Header and Data
+		Header header
+		Data   []byte `bin:"len:Size - sizeof(Header)"`
+		Pad    []byte `bin:"len:align(pos(), 4) - pos()"`
+		Copy   []byte `bin:"len:len(Data)"`
+	}
+
+	data := []byte{
+		0x00, 0x09, // Size
+		'a', 'b', 'c', 'd', 0x00, 0x01, 0xa3, // Header
+		'x', 'y', // Data
+		0x00,     // Pad
+		'z', 'w', // Copy
+	}
+
+	want := dataStruct{
+		Size:   9,
+		Header: header{Magic: [4]byte{'a', 'b', 'c', 'd'}, Version: 1, Flags: 5, Kind: 3},
+		Data:   []byte{'x', 'y'},
+		Pad:    []byte{0x00},
+		Copy:   []byte{'z', 'w'},
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+}
+
+func Test_FuncsRemaining(t *testing.T) {
+	type dataStruct struct {
+		Count uint8
+		Items []uint8 `bin:"len:min(Count, remaining())"`
+		Rest  []byte  `bin:"len:remaining()"`
+	}
+
+	data := []byte{0x03, 0x01, 0x02, 0x03, 0x04}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, dataStruct{Count: 3, Items: []uint8{1, 2, 3}, Rest: []byte{4}}, actual)
+
+	// The size of the output is unknown when writing, the actual length is written.
+	encoded, err := MarshalBE(actual)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+
+	encoded, err = MarshalBE(dataStruct{Count: 3, Items: []uint8{1, 2, 3}, Rest: []byte{4, 5, 6}})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x03, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06}, encoded)
+}
+
+func Test_FuncsRemainingInSize(t *testing.T) {
+	type box struct {
+		Type uint8
+		Data []byte `bin:"len:remaining()"`
+	}
+
+	type dataStruct struct {
+		Size  uint8
+		Box   box    `bin:"size:Size"`
+		Total uint8  `bin:"len:1"`
+		End   []byte `bin:"len:size() - pos()"`
+	}
+
+	data := []byte{0x03, 0x01, 0x0a, 0x0b, 0x07, 0x08, 0x09}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, dataStruct{
+		Size:  3,
+		Box:   box{Type: 1, Data: []byte{0x0a, 0x0b}},
+		Total: 7,
+		End:   []byte{0x08, 0x09},
+	}, actual)
+
+	encoded, err := MarshalBE(actual)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+}
+
+func Test_FuncsErrors(t *testing.T) {
+	type dataUnknown struct {
+		Value []byte `bin:"len:sizof(Value)"`
+	}
+
+	var actualUnknown dataUnknown
+	err := UnmarshalBE([]byte{0x01}, &actualUnknown)
+	require.EqualError(t, err, `failed parseTag for field "Value": invalid expression "sizof(Value)": unknown function "sizof" at 0`)
+
+	type dataArgs struct {
+		Value []byte `bin:"len:align(2)"`
+	}
+
+	var actualArgs dataArgs
+	err = UnmarshalBE([]byte{0x01}, &actualArgs)
+	require.EqualError(t, err, `failed parseTag for field "Value": invalid expression "align(2)": wrong number of arguments of align() at 0`)
+
+	type dataNotStatic struct {
+		Name  string `bin:"cstring"`
+		Value []byte `bin:"len:sizeof(Name)"`
+	}
+
+	var actualNotStatic dataNotStatic
+	err = UnmarshalBE([]byte{0x00, 0x01}, &actualNotStatic)
+	require.EqualError(t, err, `failed parse ReadData from tags for field "Value": size of field "Name" depends on the data`)
+}
+
+func Test_FuncsSelfReferencing(t *testing.T) {
+	type node struct {
+		Value uint8
+		Next  *node
+	}
+
+	type dataSize struct {
+		Data []byte `bin:"len:sizeof(Node)"`
+		Node node
+	}
+
+	var actualSize dataSize
+	err := UnmarshalBE([]byte{0x01}, &actualSize)
+	require.EqualError(t, err, `failed parse ReadData from tags for field "Data": size of field "Node" refers to its own type`)
+
+	type nodeLast struct {
+		Next  *nodeLast
+		Value uint8
+	}
+
+	_, err = offsetofField(reflect.TypeOf(nodeLast{}), "Value")
+	require.EqualError(t, err, `offset of field "Value" refers to its own type`)
+
+	type list struct {
+		Count uint8
+		Items []list `bin:"len:2"`
+	}
+
+	type dataSlice struct {
+		Data []byte `bin:"len:sizeof(List)"`
+		List list
+	}
+
+	var actualSlice dataSlice
+	err = UnmarshalBE([]byte{0x01}, &actualSlice)
+	require.EqualError(t, err, `failed parse ReadData from tags for field "Data": size of field "List" refers to its own type`)
+}
+
 func Test_StringWithLenFromField(t *testing.T) {
 	data := []byte{0x00, 0x05, 'h', 'e', 'l', 'l', 'o'}
 
@@ -773,6 +2122,110 @@ or
 	require.Equal(t, dataCustomMethod3Struct{}, actual)
 }
 
+type dataCustomMethodArgsStruct struct {
+	Count   uint8
+	Entries []uint16 `bin:"ReadEntries(Count, 2), write:WriteEntries(Count)"`
+}
+
+func (*dataCustomMethodArgsStruct) ReadEntries(r Reader, count int, size uint8) ([]uint16, error) {
+	entries := make([]uint16, count)
+	for i := range entries {
+		v, err := r.ReadUintX(int(size))
+		if err != nil {
+			return nil, err
+		}
+
+		entries[i] = uint16(v)
+	}
+
+	return entries, nil
+}
+
+func (*dataCustomMethodArgsStruct) WriteEntries(w Writer, entries []uint16, count int64) error {
+	if int64(len(entries)) != count {
+		return fmt.Errorf("expected %d entries, got %d", count, len(entries))
+	}
+
+	for _, e := range entries {
+		err := w.WriteUint16(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func Test_CustomMethodArgs(t *testing.T) {
+	data := []byte{0x02, 0x00, 0x01, 0x00, 0x02}
+
+	want := dataCustomMethodArgsStruct{
+		Count:   2,
+		Entries: []uint16{1, 2},
+	}
+
+	var actual dataCustomMethodArgsStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	encoded, err := MarshalBE(want)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+}
+
+type dataCustomMethodArgsErrorsStruct struct {
+	Count uint8
+}
+
+func (*dataCustomMethodArgsErrorsStruct) ReadEntries(Reader, int, uint8) ([]uint16, error) {
+	return nil, nil
+}
+
+func (*dataCustomMethodArgsErrorsStruct) ReadString(Reader, string) error {
+	return nil
+}
+
+func Test_CustomMethodArgsErrors(t *testing.T) {
+	type dataArity struct {
+		dataCustomMethodArgsErrorsStruct
+		Value []uint16 `bin:"ReadEntries(Count)"`
+	}
+
+	var actualArity dataArity
+	err := UnmarshalBE([]byte{0x01}, &actualArity)
+	require.EqualError(t, err, `failed set value to field "Value": call custom func(dataArity): `+
+		`ReadEntries: 1 arguments in the tag, but the method has 2 parameters after the reader`)
+
+	type dataType struct {
+		dataCustomMethodArgsErrorsStruct
+		Value []uint16 `bin:"ReadString(Count)"`
+	}
+
+	var actualType dataType
+	err = UnmarshalBE([]byte{0x01}, &actualType)
+	require.EqualError(t, err, `failed set value to field "Value": call custom func(dataType): `+
+		`ReadString: argument 1: parameter of type string is not an integer`)
+
+	type dataRange struct {
+		dataCustomMethodArgsErrorsStruct
+		Value []uint16 `bin:"ReadEntries(Count, 256)"`
+	}
+
+	var actualRange dataRange
+	err = UnmarshalBE([]byte{0x01}, &actualRange)
+	require.EqualError(t, err, `failed set value to field "Value": call custom func(dataRange): `+
+		`ReadEntries: argument 2: value 256 overflows uint8`)
+
+	type dataInvalid struct {
+		Value []uint16 `bin:"ReadEntries(Count"`
+	}
+
+	var actualInvalid dataInvalid
+	err = UnmarshalBE([]byte{0x01}, &actualInvalid)
+	require.EqualError(t, err, `failed parseTag for field "Value": invalid method call "ReadEntries(Count": missing ")"`)
+}
+
 func Test_InvalidType(t *testing.T) {
 	data := []byte{}
 
@@ -786,6 +2239,38 @@ func Test_InvalidType(t *testing.T) {
 	require.Equal(t, dataStruct{}, actual)
 }
 
+func Test_LenErrors(t *testing.T) {
+	type dataSlice struct {
+		Count int8
+		Items []uint16 `bin:"len:Count"`
+	}
+
+	var actualSlice dataSlice
+	err := UnmarshalBE([]byte{0xff}, &actualSlice)
+	require.EqualError(t, err, `failed set value to field "Items": binstruct: negative count`)
+
+	type dataBytes struct {
+		Count int8
+		Data  []byte `bin:"len:Count-4"`
+	}
+
+	var actualBytes dataBytes
+	err = UnmarshalBE([]byte{0x02}, &actualBytes)
+	require.EqualError(t, err, `failed set value to field "Data": binstruct: negative count`)
+
+	type dataArray struct {
+		Count int8
+		Items [2]uint8 `bin:"len:Count"`
+	}
+
+	var actualArray dataArray
+	err = UnmarshalBE([]byte{0xfe}, &actualArray)
+	require.EqualError(t, err, `failed set value to field "Items": binstruct: negative count`)
+
+	err = UnmarshalBE([]byte{0x03, 0x01, 0x02, 0x03}, &actualArray)
+	require.EqualError(t, err, `failed set value to field "Items": len 3 is greater than array len 2`)
+}
+
 type CustomMethodFromParent struct {
 	Pin struct {
 		Checksum uint16 `bin:"CustomMethodFromParent,len:2"`
@@ -933,3 +2418,185 @@ func Test_OffsetRestore(t *testing.T) {
 	require.Equal(t, []byte{0x04, 0x05}, v.Data)
 	require.Equal(t, []byte{0x01, 0x02, 0x03}, v.Other)
 }
+
+func Test_EncoderToWriteSeeker(t *testing.T) {
+	type dataStruct struct {
+		First byte
+		Last  byte `bin:"offsetEnd:0"`
+	}
+
+	var data []byte
+	ws := &bytesWriteSeeker{buf: &data}
+
+	enc := NewEncoder(ws, binary.BigEndian)
+	require.NoError(t, enc.Encode(dataStruct{First: 0x01, Last: 0x02}))
+	require.NoError(t, enc.Encode(&dataStruct{First: 0x03, Last: 0x04}))
+	require.Equal(t, []byte{0x01, 0x02, 0x03, 0x04}, data)
+}
+
+func Test_EncoderToWriterPositions(t *testing.T) {
+	// The padding depends on the position in the stream, not in the value.
+	type record struct {
+		Tag   uint8
+		Pad   []byte `bin:"len:align(pos(), 4) - pos()"`
+		Value uint16
+	}
+
+	values := []record{
+		{Tag: 0x01, Pad: []byte{0x00, 0x00, 0x00}, Value: 0x0203},
+		{Tag: 0x04, Pad: []byte{0x00}, Value: 0x0506},
+		{Tag: 0x07, Pad: []byte{0x00}, Value: 0x0809},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, binary.BigEndian)
+	for _, v := range values {
+		require.NoError(t, enc.Encode(v))
+	}
+
+	var data []byte
+	seekEnc := NewEncoder(&bytesWriteSeeker{buf: &data}, binary.BigEndian)
+	for _, v := range values {
+		require.NoError(t, seekEnc.Encode(v))
+	}
+
+	want := []byte{
+		0x01, 0x00, 0x00, 0x00, 0x02, 0x03,
+		0x04, 0x00, 0x05, 0x06,
+		0x07, 0x00, 0x08, 0x09,
+	}
+	require.Equal(t, want, buf.Bytes())
+	require.Equal(t, want, data)
+
+	dec := NewDecoder(bytes.NewReader(buf.Bytes()), binary.BigEndian)
+	for _, v := range values {
+		var actual record
+		require.NoError(t, dec.Decode(&actual))
+		require.Equal(t, v, actual)
+	}
+}
+
+func Test_EncoderToWriterSeekBeforeValue(t *testing.T) {
+	type dataStruct struct {
+		A uint8
+		B uint8 `bin:"offsetStart:4"`
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, binary.BigEndian)
+	require.NoError(t, enc.Encode(dataStruct{A: 0x01, B: 0x02}))
+	require.Equal(t, []byte{0x01, 0x00, 0x00, 0x00, 0x02}, buf.Bytes())
+
+	err := enc.Encode(dataStruct{A: 0x03, B: 0x04})
+	require.EqualError(t, err, `failed write value from field "B": set offset: seek: binstruct: cannot seek to 4, the data before 5 is already written`)
+	require.Equal(t, []byte{0x01, 0x00, 0x00, 0x00, 0x02}, buf.Bytes())
+}
+
+type dosDate struct {
+	Year  int
+	Month int
+	Day   int
+}
+
+func (d *dosDate) UnmarshalBinstruct(r Reader) error {
+	v, err := r.ReadUint16()
+	if err != nil {
+		return err
+	}
+
+	d.Year = int(v>>9) + 1980
+	d.Month = int(v>>5) & 0x0f
+	d.Day = int(v) & 0x1f
+	return nil
+}
+
+func (d dosDate) MarshalBinstruct(w Writer) error {
+	return w.WriteUint16(uint16(d.Year-1980)<<9 | uint16(d.Month)<<5 | uint16(d.Day))
+}
+
+type pascalString string
+
+func (s *pascalString) UnmarshalBinstruct(r Reader) error {
+	l, err := r.ReadUint8()
+	if err != nil {
+		return err
+	}
+
+	_, b, err := r.ReadBytes(int(l))
+	if err != nil {
+		return err
+	}
+
+	*s = pascalString(b)
+	return nil
+}
+
+func (s *pascalString) MarshalBinstruct(w Writer) error {
+	err := w.WriteUint8(uint8(len(*s)))
+	if err != nil {
+		return err
+	}
+
+	return w.WriteBytes([]byte(*s))
+}
+
+type dataUnmarshalerStruct struct {
+	Date    dosDate
+	DateLE  dosDate        `bin:"le"`
+	Names   []pascalString `bin:"len:2"`
+	_       pascalString
+	Trailer uint8
+}
+
+func Test_Unmarshaler(t *testing.T) {
+	data := []byte{
+		0x52, 0x21, // Date
+		0x21, 0x52, // DateLE
+		0x02, 'h', 'i', // Names[0]
+		0x03, 'y', 'a', 'y', // Names[1]
+		0x01, 'x', // _
+		0xff, // Trailer
+	}
+
+	want := dataUnmarshalerStruct{
+		Date:    dosDate{Year: 2021, Month: 1, Day: 1},
+		DateLE:  dosDate{Year: 2021, Month: 1, Day: 1},
+		Names:   []pascalString{"hi", "yay"},
+		Trailer: 0xff,
+	}
+
+	var actual dataUnmarshalerStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	var date dosDate
+	err = UnmarshalBE(data, &date)
+	require.NoError(t, err)
+	require.Equal(t, want.Date, date)
+}
+
+type embeddedHeader struct {
+	Len uint8
+}
+
+func Test_EmbeddedUnexportedStruct(t *testing.T) {
+	type dataStruct struct {
+		embeddedHeader
+		Data []byte `bin:"len:Len"`
+	}
+
+	want := dataStruct{
+		embeddedHeader: embeddedHeader{Len: 2},
+		Data:           []byte{0x01, 0x02},
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE([]byte{0x02, 0x01, 0x02}, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	data, err := MarshalBE(dataStruct{Data: []byte{0x01, 0x02}})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x02, 0x01, 0x02}, data)
+}
@@ -0,0 +1,194 @@
+package binstruct
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/ghostiam/binstruct/internal/bintag"
+)
+
+// bitReader reads bits of the shared bytes, for adjacent bit fields of a struct
+// or for the bit methods of Reader.
+type bitReader struct {
+	b   byte // current byte
+	n   int  // number of bits left in the current byte
+	lsb bool // bit order of the current byte
+
+	order string // default bit order of the struct
+}
+
+// align skips the rest of the current byte, so the next field starts at the byte boundary.
+func (br *bitReader) align() {
+	br.n = 0
+}
+
+// readBits reads n bits, the first bit is the most significant bit of the value if msb order,
+// or the least significant bit if lsb order. The bytes are read from r one by one.
+// If an EOF happens after reading some but not all the bits, readBits returns io.ErrUnexpectedEOF.
+func (br *bitReader) readBits(r io.Reader, n int, lsb bool) (uint64, error) {
+	if br.n > 0 && br.lsb != lsb {
+		return 0, errors.New("bit order changed in the middle of a byte")
+	}
+
+	var v uint64
+	var buf [1]byte
+	for i := 0; i < n; i++ {
+		if br.n == 0 {
+			_, err := io.ReadFull(r, buf[:])
+			if err != nil {
+				if err == io.EOF && i > 0 {
+					err = io.ErrUnexpectedEOF
+				}
+				return 0, err
+			}
+
+			br.b, br.n, br.lsb = buf[0], 8, lsb
+		}
+
+		if lsb {
+			v |= uint64(br.b>>(8-br.n)&1) << i
+		} else {
+			v = v<<1 | uint64(br.b>>(br.n-1)&1)
+		}
+
+		br.n--
+	}
+
+	return v, nil
+}
+
+func (br *bitReader) setValueToField(r Reader, fieldValue reflect.Value, fieldData *fieldReadData) error {
+	n, err := bitsCount(fieldValue.Type(), *fieldData.Bits)
+	if err != nil {
+		return err
+	}
+
+	v, err := br.readBits(r, n, isLSB(fieldData.BitOrder, br.order))
+	if err != nil {
+		return err
+	}
+
+	if !fieldValue.CanSet() {
+		return nil
+	}
+
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// Sign-extend the value from n bits.
+		fieldValue.SetInt(int64(v<<(64-n)) >> (64 - n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fieldValue.SetUint(v)
+	case reflect.Bool:
+		fieldValue.SetBool(v != 0)
+	}
+
+	return nil
+}
+
+// bitWriter writes adjacent bit fields of a struct to the shared bytes.
+type bitWriter struct {
+	b   byte // current byte
+	n   int  // number of bits written to the current byte
+	lsb bool // bit order of the current byte
+
+	order string // default bit order of the struct
+}
+
+// flush writes the current byte, the unused bits are zeros.
+func (bw *bitWriter) flush(w Writer) error {
+	if bw.n == 0 {
+		return nil
+	}
+
+	b := bw.b
+	bw.b, bw.n = 0, 0
+	return w.WriteByte(b)
+}
+
+// writeBits writes n low bits of v in the same order as readBits reads them.
+func (bw *bitWriter) writeBits(w Writer, v uint64, n int, lsb bool) error {
+	if bw.n > 0 && bw.lsb != lsb {
+		return errors.New("bit order changed in the middle of a byte")
+	}
+	bw.lsb = lsb
+
+	for i := 0; i < n; i++ {
+		if lsb {
+			bw.b |= byte(v>>i&1) << bw.n
+		} else {
+			bw.b |= byte(v>>(n-1-i)&1) << (7 - bw.n)
+		}
+
+		bw.n++
+		if bw.n == 8 {
+			err := bw.flush(w)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (bw *bitWriter) writeValueFromField(w Writer, fieldValue reflect.Value, fieldData *fieldReadData) error {
+	n, err := bitsCount(fieldValue.Type(), *fieldData.Bits)
+	if err != nil {
+		return err
+	}
+
+	var v uint64
+	// Unexported and blank bit fields are skipped on read, so write zeros in their place.
+	if fieldValue.CanInterface() {
+		switch fieldValue.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i := fieldValue.Int()
+			if n < 64 && (i < -1<<(n-1) || i >= 1<<(n-1)) {
+				return fmt.Errorf("value %d overflows %d bits", i, n)
+			}
+			v = uint64(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			v = fieldValue.Uint()
+			if n < 64 && v >= 1<<n {
+				return fmt.Errorf("value %d overflows %d bits", v, n)
+			}
+		case reflect.Bool:
+			if fieldValue.Bool() {
+				v = 1
+			}
+		}
+	}
+
+	return bw.writeBits(w, v, n, isLSB(fieldData.BitOrder, bw.order))
+}
+
+// bitsCount returns the number of bits of the bit field of type t.
+func bitsCount(t reflect.Type, bits int64) (int, error) {
+	max := 64
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		max = t.Bits()
+	case reflect.Bool:
+	default:
+		return 0, errors.New("bits is supported only for integers and bools")
+	}
+
+	if bits < 1 || bits > int64(max) {
+		return 0, fmt.Errorf("bits %d is out of range 1..%d for %s", bits, max, t)
+	}
+
+	return int(bits), nil
+}
+
+// isLSB reports whether the bit field is read in lsb order,
+// by the tag of the field or by the default order of the struct.
+func isLSB(fieldOrder, structOrder string) bool {
+	if fieldOrder != "" {
+		return fieldOrder == bintag.TypeBitsLSB
+	}
+
+	return structOrder == bintag.TypeBitsLSB
+}
@@ -0,0 +1,992 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghostiam/binstruct/internal/bintag"
+)
+
+const binstructPath = "github.com/ghostiam/binstruct"
+
+// maxSliceSizeHint is the max number of slice elements preallocated before reading,
+// as in the binstruct package.
+const maxSliceSizeHint = 1024
+
+// generator writes UnmarshalBinstruct methods that do the same as
+// the reflection based decoder of the binstruct package, but with
+// straight-line calls of binstruct.Reader methods.
+type generator struct {
+	pkg      *types.Package
+	generate map[*types.TypeName]bool
+
+	imports map[string]string // path -> name
+	buf     bytes.Buffer
+	n       int // counter of unique variable names
+
+	values []valueDecl     // tag values of the current field
+	used   map[string]bool // variables of the tag values used by the generated code
+}
+
+type valueDecl struct {
+	Name string
+	Expr string
+}
+
+// structCtx describes the struct whose fields are decoded.
+type structCtx struct {
+	Expr     string // Go expression of the struct value
+	Type     types.Type
+	Struct   *types.Struct
+	Settable bool // fields can be assigned, as reflect.Value.CanSet
+	Callable bool // methods can be called
+}
+
+// valueCtx describes the value that is decoded.
+type valueCtx struct {
+	Target   string // Go expression to assign the value, empty to discard the value
+	Callable bool   // methods of the value can be called, as reflect.Value.CanInterface
+	Type     types.Type
+	Tags     *fieldTags
+	Values   []levelValues
+	Level    int
+	Prefix   string // prefix of the error messages
+}
+
+// levelValues are the calculated tag values of an element level,
+// level 0 is the field itself.
+type levelValues struct {
+	Length  string
+	Offsets []string
+}
+
+func newGenerator(pkg *types.Package) *generator {
+	return &generator{
+		pkg:      pkg,
+		generate: make(map[*types.TypeName]bool),
+		imports: map[string]string{
+			"fmt":         "fmt",
+			binstructPath: "binstruct",
+		},
+	}
+}
+
+// Generate returns the formatted source of UnmarshalBinstruct methods for the named struct types.
+func (g *generator) Generate(typeNames []string) ([]byte, error) {
+	var named []*types.Named
+	for _, name := range typeNames {
+		obj, ok := g.pkg.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			return nil, fmt.Errorf(`type "%s" not found`, name)
+		}
+
+		t, ok := obj.Type().(*types.Named)
+		if !ok {
+			return nil, fmt.Errorf(`type "%s" is not a named type`, name)
+		}
+
+		if _, ok := t.Underlying().(*types.Struct); !ok {
+			return nil, fmt.Errorf(`type "%s" is not a struct`, name)
+		}
+
+		if obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(t), false, g.pkg, "UnmarshalBinstruct"); obj != nil {
+			return nil, fmt.Errorf(`type "%s" already has UnmarshalBinstruct method`, name)
+		}
+
+		g.generate[obj] = true
+		named = append(named, t)
+	}
+
+	var body bytes.Buffer
+	for _, t := range named {
+		g.buf.Reset()
+
+		err := g.genType(t)
+		if err != nil {
+			return nil, fmt.Errorf(`type "%s": %w`, t.Obj().Name(), err)
+		}
+
+		body.Write(g.buf.Bytes())
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by binstructgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package %s\n\n", g.pkg.Name())
+
+	paths := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// Standard packages first.
+	sort.SliceStable(paths, func(i, j int) bool {
+		return !strings.Contains(paths[i], ".") && strings.Contains(paths[j], ".")
+	})
+
+	fmt.Fprintf(&out, "import (\n")
+	for k, path := range paths {
+		if k != 0 && strings.Contains(path, ".") && !strings.Contains(paths[k-1], ".") {
+			fmt.Fprintf(&out, "\n")
+		}
+
+		name := g.imports[path]
+		if name == path[strings.LastIndex(path, "/")+1:] {
+			fmt.Fprintf(&out, "%s\n", strconv.Quote(path))
+		} else {
+			fmt.Fprintf(&out, "%s %s\n", name, strconv.Quote(path))
+		}
+	}
+	fmt.Fprintf(&out, ")\n")
+	out.Write(body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+
+	return src, nil
+}
+
+func (g *generator) genType(t *types.Named) error {
+	name := t.Obj().Name()
+	g.printf("\n// UnmarshalBinstruct implements binstruct.Unmarshaler.\n")
+	g.printf("func (s *%s) UnmarshalBinstruct(r binstruct.Reader) error {\n", name)
+
+	ctx := structCtx{
+		Expr:     "s",
+		Type:     t,
+		Struct:   t.Underlying().(*types.Struct),
+		Settable: true,
+		Callable: true,
+	}
+
+	err := g.genStruct([]structCtx{ctx}, "")
+	if err != nil {
+		return err
+	}
+
+	g.printf("return nil\n")
+	g.printf("}\n")
+	return nil
+}
+
+// genStruct decodes the fields of the last struct of the chain,
+// the previous are the parents used to look up methods.
+func (g *generator) genStruct(chain []structCtx, prefix string) error {
+	ctx := chain[len(chain)-1]
+
+	first := true
+	for i := 0; i < ctx.Struct.NumFields(); i++ {
+		f := ctx.Struct.Field(i)
+
+		tags, err := bintag.Parse(reflect.StructTag(ctx.Struct.Tag(i)).Get(bintag.Name))
+		if err != nil {
+			return fmt.Errorf(`failed parseTag for field "%s": %w`, f.Name(), err)
+		}
+
+		fieldTags, err := parseFieldTags(tags)
+		if err != nil {
+			return fmt.Errorf(`field "%s": %w`, f.Name(), err)
+		}
+
+		if fieldTags.Ignore {
+			continue
+		}
+
+		if !first {
+			g.printf("\n")
+		}
+		first = false
+		g.printf("// %s\n", f.Name())
+		g.printf("{\n")
+
+		// The tag values are calculated before the field is read,
+		// the declarations are inserted when the field is generated.
+		outerUsed := g.used
+		g.values, g.used = nil, make(map[string]bool)
+		values, err := g.genValues(ctx, fieldTags)
+		if err != nil {
+			return fmt.Errorf(`failed parse ReadData from tags for field "%s": %w`, f.Name(), err)
+		}
+		decls, start := g.values, g.buf.Len()
+
+		v := valueCtx{
+			Type:   f.Type(),
+			Tags:   fieldTags,
+			Values: values,
+			Prefix: prefix + fmt.Sprintf(`failed set value to field "%s": `, f.Name()),
+		}
+
+		// Unexported fields are read, but can't be set, except the fields of embedded structs.
+		_, isStruct := f.Type().Underlying().(*types.Struct)
+		switch {
+		case ctx.Settable && f.Exported():
+			v.Target = ctx.Expr + "." + f.Name()
+		case ctx.Settable && f.Embedded() && isStruct && !g.isUnmarshaler(f.Type()):
+			v.Target = ctx.Expr + "." + f.Name()
+		}
+
+		v.Callable = v.Target != "" && f.Exported()
+
+		used := g.used
+		err = g.genValue(chain, v)
+		if err != nil {
+			return fmt.Errorf(`field "%s": %w`, f.Name(), err)
+		}
+
+		var declBuf bytes.Buffer
+		for _, d := range decls {
+			if used[d.Name] {
+				fmt.Fprintf(&declBuf, "%s := %s\n", d.Name, d.Expr)
+			}
+		}
+
+		code := append(declBuf.Bytes(), g.buf.Bytes()[start:]...)
+		g.buf.Truncate(start)
+		g.buf.Write(code)
+		g.used = outerUsed
+
+		g.printf("}\n")
+	}
+
+	return nil
+}
+
+// genValues calculates the tag values of all levels before the field is read.
+func (g *generator) genValues(ctx structCtx, tags *fieldTags) ([]levelValues, error) {
+	var values []levelValues
+	for ; tags != nil; tags = tags.Elem {
+		var lv levelValues
+
+		if tags.Length != nil {
+			v, err := g.genValue64(ctx, *tags.Length)
+			if err != nil {
+				return nil, err
+			}
+			lv.Length = v
+		}
+
+		for _, o := range tags.Offsets {
+			v, err := g.genValue64(ctx, o.Value)
+			if err != nil {
+				return nil, err
+			}
+			lv.Offsets = append(lv.Offsets, v)
+		}
+
+		values = append(values, lv)
+
+		if tags.Ignore {
+			break
+		}
+	}
+
+	return values, nil
+}
+
+// genValue64 returns the Go expression of the int64 tag value. If the value refers
+// to fields, it is assigned to a variable, otherwise the constant is returned.
+func (g *generator) genValue64(ctx structCtx, v string) (string, error) {
+	v = strings.TrimSpace(v)
+
+	if strings.Contains(v, "$") || strings.Contains(v, "../") {
+		return "", fmt.Errorf(`expression "%s": variables and fields of parent structs are not supported`, v)
+	}
+
+	v = decimalLiterals(v)
+	e, err := parser.ParseExpr(v)
+	if err != nil {
+		return "", fmt.Errorf(`invalid expression "%s": %w`, v, err)
+	}
+
+	expr, isConst, err := g.exprGo(ctx, v, e)
+	if err != nil {
+		return "", err
+	}
+
+	if isConst {
+		return expr, nil
+	}
+
+	name := g.name("val")
+	g.values = append(g.values, valueDecl{Name: name, Expr: expr})
+	return name, nil
+}
+
+// decimalLiterals removes leading zeros of integer literals, such as 010,
+// which the binstruct package reads as decimal and Go as octal.
+func decimalLiterals(v string) string {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(v))
+
+	var s scanner.Scanner
+	s.Init(file, []byte(v), nil, 0)
+
+	var b strings.Builder
+	var last int
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		if tok != token.INT || len(lit) < 2 || lit[0] != '0' || lit[1] < '0' || lit[1] > '9' {
+			continue
+		}
+
+		digits := strings.TrimLeft(lit, "0")
+		if digits == "" {
+			digits = "0"
+		}
+
+		offset := file.Offset(pos)
+		b.WriteString(v[last:offset])
+		b.WriteString(digits)
+		last = offset + len(lit)
+	}
+	b.WriteString(v[last:])
+
+	return b.String()
+}
+
+// exprGo returns the Go code of the int64 expression e of the tag value src.
+// Constant subexpressions are folded, so comparisons result in 1 or 0 as in the binstruct package.
+func (g *generator) exprGo(ctx structCtx, src string, e ast.Expr) (code string, isConst bool, err error) {
+	if !refersToFields(e) {
+		c, err := constValue(src[e.Pos()-1 : e.End()-1])
+		if err != nil {
+			return "", false, fmt.Errorf(`invalid expression "%s": %w`, src, err)
+		}
+
+		return strconv.FormatInt(c, 10), true, nil
+	}
+
+	switch e := e.(type) {
+	case *ast.Ident, *ast.SelectorExpr:
+		name, ok := fieldName(e)
+		if !ok {
+			break
+		}
+
+		expr, err := g.fieldExpr(ctx, name)
+		if err != nil {
+			return "", false, err
+		}
+
+		return "int64(" + expr + ")", false, nil
+
+	case *ast.ParenExpr:
+		x, _, err := g.exprGo(ctx, src, e.X)
+		if err != nil {
+			return "", false, err
+		}
+
+		return "(" + x + ")", false, nil
+
+	case *ast.UnaryExpr:
+		switch e.Op {
+		case token.ADD, token.SUB, token.XOR:
+			x, _, err := g.exprGo(ctx, src, e.X)
+			if err != nil {
+				return "", false, err
+			}
+
+			return e.Op.String() + x, false, nil
+		}
+
+		return "", false, fmt.Errorf(`expression "%s": operator "%s" on fields is not supported`, src, e.Op)
+
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case token.ADD, token.SUB, token.MUL, token.AND, token.OR, token.XOR, token.AND_NOT:
+		case token.QUO, token.REM, token.SHL, token.SHR:
+			// The binstruct package returns the error instead of panic.
+			if refersToFields(e.Y) {
+				return "", false, fmt.Errorf(`expression "%s": operator "%s" with the field on the right is not supported`, src, e.Op)
+			}
+		default:
+			return "", false, fmt.Errorf(`expression "%s": operator "%s" on fields is not supported`, src, e.Op)
+		}
+
+		x, _, err := g.exprGo(ctx, src, e.X)
+		if err != nil {
+			return "", false, err
+		}
+
+		y, yConst, err := g.exprGo(ctx, src, e.Y)
+		if err != nil {
+			return "", false, err
+		}
+
+		if yConst && y == "0" && (e.Op == token.QUO || e.Op == token.REM) {
+			return "", false, fmt.Errorf(`division by zero in "%s"`, src)
+		}
+
+		return "(" + x + " " + e.Op.String() + " " + y + ")", false, nil
+
+	case *ast.CallExpr:
+		return "", false, fmt.Errorf(`expression "%s": functions are not supported`, src)
+	}
+
+	return "", false, fmt.Errorf(`expression "%s" is not supported`, src)
+}
+
+// refersToFields reports whether the expression has identifiers.
+func refersToFields(e ast.Expr) bool {
+	var found bool
+	ast.Inspect(e, func(n ast.Node) bool {
+		if _, ok := n.(*ast.Ident); ok {
+			found = true
+		}
+		return !found
+	})
+
+	return found
+}
+
+// fieldName returns the field name of the identifier or the selector,
+// the names of nested struct fields are separated by a dot.
+func fieldName(e ast.Expr) (string, bool) {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name, true
+	case *ast.SelectorExpr:
+		x, ok := fieldName(e.X)
+		return x + "." + e.Sel.Name, ok
+	default:
+		return "", false
+	}
+}
+
+// constValue evaluates the constant expression, comparisons result in 1 or 0.
+func constValue(src string) (int64, error) {
+	tv, err := types.Eval(token.NewFileSet(), nil, token.NoPos, src)
+	if err != nil {
+		return 0, err
+	}
+
+	switch tv.Value.Kind() {
+	case constant.Bool:
+		if constant.BoolVal(tv.Value) {
+			return 1, nil
+		}
+		return 0, nil
+	case constant.Int:
+		c, ok := constant.Int64Val(tv.Value)
+		if !ok {
+			return 0, fmt.Errorf("%s overflows int64", tv.Value)
+		}
+		return c, nil
+	default:
+		return 0, fmt.Errorf("%s is not an integer", tv.Value)
+	}
+}
+
+// fieldExpr returns the Go expression of the integer field found by name,
+// the names of nested struct fields are separated by a dot.
+func (g *generator) fieldExpr(ctx structCtx, name string) (string, error) {
+	expr := ctx.Expr
+
+	t := ctx.Type
+	for _, s := range strings.Split(name, ".") {
+		obj, _, _ := types.LookupFieldOrMethod(t, true, g.pkg, s)
+		f, ok := obj.(*types.Var)
+		if !ok || !f.IsField() {
+			return "", errors.New("can't get field len from \"" + name + "\" field")
+		}
+
+		expr += "." + s
+
+		t = f.Type()
+		if _, ok := t.Underlying().(*types.Struct); !ok {
+			break
+		}
+	}
+
+	if b, ok := t.Underlying().(*types.Basic); ok && b.Info()&types.IsInteger != 0 {
+		return expr, nil
+	}
+
+	return "", errors.New("can't get field len from \"" + name + "\" field")
+}
+
+// genValue decodes the value, as setValueToField of the binstruct package does.
+func (g *generator) genValue(chain []structCtx, v valueCtx) error {
+	tags := v.Tags
+	if tags == nil {
+		tags = &fieldTags{}
+	}
+
+	if tags.Ignore {
+		return nil
+	}
+
+	var values levelValues
+	if v.Level < len(v.Values) {
+		values = v.Values[v.Level]
+	}
+
+	rd := "r"
+	if tags.Order != "" && g.usesOrder(v.Type, tags) {
+		g.imports["encoding/binary"] = "binary"
+		rd = g.name("r")
+		g.printf("%s := r.WithOrder(binary.%s)\n", rd, tags.Order)
+	}
+
+	var pos string
+	if tags.OffsetRestore {
+		g.imports["io"] = "io"
+		pos = g.name("pos")
+		g.printf("%s, err := %s.Seek(0, io.SeekCurrent)\n", pos, rd)
+		g.printErr(v.Prefix + "get current offset: ")
+	}
+
+	for k, o := range tags.Offsets {
+		g.imports["io"] = "io"
+		g.printf("if _, err := %s.Seek(%s, %s); err != nil {\n", rd, g.use(values.Offsets[k]), whenceName(o.Whence))
+		g.printReturnErr(v.Prefix + "set offset: seek: ")
+		g.printf("}\n")
+	}
+
+	err := g.genKind(chain, v, tags, values, rd)
+	if err != nil {
+		return err
+	}
+
+	if pos != "" {
+		g.printf("_, _ = %s.Seek(%s, io.SeekStart)\n", rd, pos)
+	}
+
+	return nil
+}
+
+// usesOrder reports whether the value is read by the reader with the byte order of the tags.
+// Elements of arrays and slices, nested structs and slices of bytes are read by the base reader.
+func (g *generator) usesOrder(t types.Type, tags *fieldTags) bool {
+	if tags.OffsetRestore || len(tags.Offsets) != 0 || tags.FuncName != "" || g.isUnmarshaler(t) {
+		return true
+	}
+
+	_, ok := t.Underlying().(*types.Basic)
+	return ok
+}
+
+func (g *generator) genKind(
+	chain []structCtx, v valueCtx, tags *fieldTags, values levelValues, rd string,
+) error {
+	if tags.FuncName != "" {
+		return g.genCallFunc(chain, v, tags.FuncName, rd)
+	}
+
+	if g.isUnmarshaler(v.Type) {
+		target := v.Target
+		if target == "" {
+			target = g.name("tmp")
+			g.printf("var %s %s\n", target, g.typeString(v.Type))
+		}
+
+		g.printf("if err := %s.UnmarshalBinstruct(%s); err != nil {\n", target, rd)
+		g.printReturnErr(v.Prefix)
+		g.printf("}\n")
+		return nil
+	}
+
+	switch t := v.Type.Underlying().(type) {
+	case *types.Basic:
+		return g.genBasic(v, t, values, rd)
+
+	case *types.Slice:
+		if values.Length == "" {
+			return errors.New("need set tag with len for slice")
+		}
+
+		// If slice of bytes, read bytes and set to slice.
+		if b, ok := t.Elem().Underlying().(*types.Basic); ok && b.Kind() == types.Uint8 {
+			if !types.Identical(t, types.NewSlice(types.Typ[types.Uint8])) {
+				return fmt.Errorf(`type "%s" not supported`, g.typeString(v.Type))
+			}
+
+			g.printf("n, b, err := r.ReadBytes(%s)\n", g.useInt(values.Length))
+			g.printErr(v.Prefix)
+			g.printf("if n != %s {\n", g.useInt(values.Length))
+			g.printf("return fmt.Errorf(%s, %s, n)\n", strconv.Quote(v.Prefix+"expected %d, got %d"), g.use(values.Length))
+			g.printf("}\n")
+			g.assign(v.Target, v.Type, types.NewSlice(types.Typ[types.Uint8]), "b")
+			return nil
+		}
+
+		n := g.name("n")
+		g.printf("%s := %s\n", n, g.useInt(values.Length))
+		g.printCountCheck(n, values.Length, -1, v.Prefix)
+		if v.Target != "" {
+			// The count comes from the input, so only a small slice is preallocated,
+			// it grows as elements are read.
+			c := g.name("c")
+			g.printf("%s := %s\n", c, n)
+			g.printf("if %s > %d {\n", c, maxSliceSizeHint)
+			g.printf("%s = %d\n", c, maxSliceSizeHint)
+			g.printf("}\n")
+			g.printf("%s = make(%s, 0, %s)\n", v.Target, g.typeString(v.Type), c)
+		}
+
+		return g.genElements(chain, v, t.Elem(), n)
+
+	case *types.Array:
+		n := g.name("n")
+		if values.Length != "" {
+			g.printf("%s := %s\n", n, g.useInt(values.Length))
+			g.printCountCheck(n, values.Length, t.Len(), v.Prefix)
+		} else {
+			g.printf("%s := %d\n", n, t.Len())
+		}
+
+		return g.genElements(chain, v, t.Elem(), n)
+
+	case *types.Struct:
+		nested := structCtx{
+			Expr:     v.Target,
+			Type:     v.Type,
+			Struct:   t,
+			Settable: v.Target != "",
+			Callable: v.Callable,
+		}
+
+		if nested.Expr == "" {
+			// Fields of the discarded struct are never set, so
+			// the values of tags refer to zero fields.
+			nested.Expr = g.name("tmp")
+			g.printf("var %s %s\n", nested.Expr, g.typeString(v.Type))
+			g.printf("_ = %s\n", nested.Expr)
+		}
+
+		return g.genStruct(append(chain, nested), v.Prefix+"unmarshal struct: ")
+
+	case *types.Pointer:
+		return errors.New(`type "ptr" not supported`)
+	case *types.Map:
+		return errors.New(`type "map" not supported`)
+	case *types.Interface:
+		return errors.New(`type "interface" not supported`)
+	case *types.Chan:
+		return errors.New(`type "chan" not supported`)
+	case *types.Signature:
+		return errors.New(`type "func" not supported`)
+	default:
+		return fmt.Errorf(`type "%s" not supported`, g.typeString(v.Type))
+	}
+}
+
+func (g *generator) genBasic(v valueCtx, t *types.Basic, values levelValues, rd string) error {
+	var call string
+	var ret types.BasicKind
+
+	switch t.Kind() {
+	case types.Int, types.Int8, types.Int16, types.Int32, types.Int64:
+		if values.Length != "" {
+			call, ret = fmt.Sprintf("ReadIntX(%s)", g.useInt(values.Length)), types.Int64
+			break
+		}
+
+		switch t.Kind() {
+		case types.Int8:
+			call, ret = "ReadInt8()", types.Int8
+		case types.Int16:
+			call, ret = "ReadInt16()", types.Int16
+		case types.Int32:
+			call, ret = "ReadInt32()", types.Int32
+		case types.Int64:
+			call, ret = "ReadInt64()", types.Int64
+		default:
+			return errors.New("need set tag with len or use int8/int16/int32/int64")
+		}
+
+	case types.Uint, types.Uint8, types.Uint16, types.Uint32, types.Uint64:
+		if values.Length != "" {
+			call, ret = fmt.Sprintf("ReadUintX(%s)", g.useInt(values.Length)), types.Uint64
+			break
+		}
+
+		switch t.Kind() {
+		case types.Uint8:
+			call, ret = "ReadUint8()", types.Uint8
+		case types.Uint16:
+			call, ret = "ReadUint16()", types.Uint16
+		case types.Uint32:
+			call, ret = "ReadUint32()", types.Uint32
+		case types.Uint64:
+			call, ret = "ReadUint64()", types.Uint64
+		default:
+			return errors.New("need set tag with len or use uint8/uint16/uint32/uint64")
+		}
+
+	case types.Float32:
+		call, ret = "ReadFloat32()", types.Float32
+	case types.Float64:
+		call, ret = "ReadFloat64()", types.Float64
+	case types.Bool:
+		call, ret = "ReadBool()", types.Bool
+
+	case types.String:
+		if values.Length == "" {
+			return errors.New("need set tag with len for string")
+		}
+
+		g.printf("_, b, err := %s.ReadBytes(%s)\n", rd, g.useInt(values.Length))
+		g.printErr(v.Prefix)
+		g.assign(v.Target, v.Type, types.NewSlice(types.Typ[types.Uint8]), "b")
+		return nil
+
+	default:
+		return fmt.Errorf(`type "%s" not supported`, t.Name())
+	}
+
+	if v.Target == "" {
+		g.printf("if _, err := %s.%s; err != nil {\n", rd, call)
+		g.printReturnErr(v.Prefix)
+		g.printf("}\n")
+		return nil
+	}
+
+	g.printf("v, err := %s.%s\n", rd, call)
+	g.printErr(v.Prefix)
+	g.assign(v.Target, v.Type, types.Typ[ret], "v")
+	return nil
+}
+
+func (g *generator) genElements(chain []structCtx, v valueCtx, elem types.Type, n string) error {
+	i := g.name("i")
+	g.printf("for %s := 0; %s < %s; %s++ {\n", i, i, n, i)
+
+	// Each element is decoded to a zero value, then set to the field.
+	e := g.name("e")
+	g.printf("var %s %s\n", e, g.typeString(elem))
+
+	ev := valueCtx{
+		Target:   e,
+		Callable: true,
+		Type:     elem,
+		Tags:     v.Tags.Elem,
+		Values:   v.Values,
+		Level:    v.Level + 1,
+		Prefix:   v.Prefix,
+	}
+
+	err := g.genValue(chain, ev)
+	if err != nil {
+		return err
+	}
+
+	_, isSlice := v.Type.Underlying().(*types.Slice)
+	switch {
+	case v.Target != "" && isSlice:
+		g.printf("%s = append(%s, %s)\n", v.Target, v.Target, e)
+	case v.Target != "":
+		g.printf("%s[%s] = %s\n", v.Target, i, e)
+	default:
+		g.printf("_ = %s\n", e)
+	}
+
+	g.printf("}\n")
+	return nil
+}
+
+// genCallFunc calls the method of the struct or its parents, as callFunc of the binstruct package does.
+func (g *generator) genCallFunc(chain []structCtx, v valueCtx, funcName, rd string) error {
+	for i := len(chain) - 1; i >= 0; i-- {
+		sc := chain[i]
+		if !sc.Callable {
+			continue
+		}
+
+		obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(sc.Type), false, g.pkg, funcName)
+		fn, ok := obj.(*types.Func)
+		if !ok || !fn.Exported() {
+			continue
+		}
+
+		sig := fn.Type().(*types.Signature)
+		if sig.Params().Len() != 1 || !isBinstructType(sig.Params().At(0).Type(), "Reader") {
+			continue
+		}
+
+		message := fmt.Sprintf("call custom func(%s): ", typeName(sc.Type))
+		if i != len(chain)-1 {
+			message = fmt.Sprintf("call custom func from parent(%s): ", typeName(sc.Type))
+		}
+
+		res := sig.Results()
+		switch {
+		// Method(r binstruct.Reader) error
+		case res.Len() == 1 && isError(res.At(0).Type()):
+			g.printf("if err := %s.%s(%s); err != nil {\n", sc.Expr, funcName, rd)
+			g.printReturnErr(v.Prefix + message)
+			g.printf("}\n")
+			return nil
+
+		// Method(r binstruct.Reader) (FieldType, error)
+		case res.Len() == 2 && types.Identical(res.At(0).Type(), v.Type) && isError(res.At(1).Type()):
+			if v.Target == "" {
+				g.printf("if _, err := %s.%s(%s); err != nil {\n", sc.Expr, funcName, rd)
+				g.printReturnErr(v.Prefix + message)
+				g.printf("}\n")
+				return nil
+			}
+
+			g.printf("v, err := %s.%s(%s)\n", sc.Expr, funcName, rd)
+			g.printErr(v.Prefix + message)
+			g.printf("%s = v\n", v.Target)
+			return nil
+		}
+	}
+
+	ctx := chain[len(chain)-1]
+	message := `
+failed call method, expected methods:
+	func (*{{Struct}}) {{MethodName}}(r binstruct.Reader) error {}
+or
+	func (*{{Struct}}) {{MethodName}}(r binstruct.Reader) ({{FieldType}}, error) {}
+`
+	message = strings.NewReplacer(
+		`{{Struct}}`, typeName(ctx.Type),
+		`{{MethodName}}`, funcName,
+		`{{FieldType}}`, g.typeString(v.Type),
+	).Replace(message)
+	return errors.New(message)
+}
+
+func (g *generator) isUnmarshaler(t types.Type) bool {
+	if named, ok := t.(*types.Named); ok && g.generate[named.Obj()] {
+		return true
+	}
+
+	obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(t), false, g.pkg, "UnmarshalBinstruct")
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return false
+	}
+
+	sig := fn.Type().(*types.Signature)
+	return sig.Params().Len() == 1 && isBinstructType(sig.Params().At(0).Type(), "Reader") &&
+		sig.Results().Len() == 1 && isError(sig.Results().At(0).Type())
+}
+
+func (g *generator) assign(target string, to, from types.Type, expr string) {
+	if target == "" {
+		g.printf("_ = %s\n", expr)
+		return
+	}
+
+	if !types.Identical(to, from) {
+		expr = g.typeString(to) + "(" + expr + ")"
+	}
+
+	g.printf("%s = %s\n", target, expr)
+}
+
+func (g *generator) typeString(t types.Type) string {
+	return types.TypeString(t, func(p *types.Package) string {
+		if p == g.pkg {
+			return ""
+		}
+
+		g.imports[p.Path()] = p.Name()
+		return p.Name()
+	})
+}
+
+// use marks the tag value as used and returns it.
+func (g *generator) use(value string) string {
+	g.used[value] = true
+	return value
+}
+
+// useInt marks the tag value as used and returns it converted to int.
+func (g *generator) useInt(value string) string {
+	g.used[value] = true
+	if _, err := strconv.ParseInt(value, 10, 0); err == nil {
+		return value
+	}
+
+	return "int(" + value + ")"
+}
+
+func (g *generator) name(prefix string) string {
+	g.n++
+	return prefix + strconv.Itoa(g.n)
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// printErr prints the check of err variable.
+func (g *generator) printErr(prefix string) {
+	g.printf("if err != nil {\n")
+	g.printReturnErr(prefix)
+	g.printf("}\n")
+}
+
+// printCountCheck prints the check of the number of elements n calculated from the value,
+// which must not be negative, or greater than max if max isn't negative. Constants are checked here.
+func (g *generator) printCountCheck(n, value string, max int64, prefix string) {
+	if c, err := strconv.ParseInt(value, 10, 64); err == nil && c >= 0 && (max < 0 || c <= max) {
+		return
+	}
+
+	g.printf("if %s < 0 {\n", n)
+	g.printf("return fmt.Errorf(%s, binstruct.ErrNegativeCount)\n", strconv.Quote(prefix+"%w"))
+	g.printf("}\n")
+
+	if max >= 0 {
+		g.printf("if %s > %d {\n", n, max)
+		g.printf("return fmt.Errorf(%s, %s, %d)\n", strconv.Quote(prefix+"len %d is greater than array len %d"), n, max)
+		g.printf("}\n")
+	}
+}
+
+func (g *generator) printReturnErr(prefix string) {
+	g.printf("return fmt.Errorf(%s, err)\n", strconv.Quote(prefix+"%w"))
+}
+
+func whenceName(whence int) string {
+	switch whence {
+	case io.SeekStart:
+		return "io.SeekStart"
+	case io.SeekEnd:
+		return "io.SeekEnd"
+	default:
+		return "io.SeekCurrent"
+	}
+}
+
+// typeName returns the name of the type as reflect.Type.Name does.
+func typeName(t types.Type) string {
+	if named, ok := t.(*types.Named); ok {
+		return named.Obj().Name()
+	}
+
+	return ""
+}
+
+func isBinstructType(t types.Type, name string) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == binstructPath && named.Obj().Name() == name
+}
+
+func isError(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
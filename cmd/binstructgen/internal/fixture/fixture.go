@@ -0,0 +1,83 @@
+// Package fixture contains structs to check that the generated
+// decoders are the same as binstruct.Unmarshal.
+package fixture
+
+import (
+	"encoding/binary"
+
+	"github.com/ghostiam/binstruct"
+)
+
+//go:generate go run github.com/ghostiam/binstruct/cmd/binstructgen -type=Header,Entry -output=fixture_binstruct.go
+
+type Header struct {
+	Magic   [4]byte
+	Version uint16 `bin:"le"`
+	NameLen uint8
+	Name    string    `bin:"len:NameLen"`
+	Count   int32     `bin:"len:3"`
+	Entries []Entry   `bin:"len:Count"`
+	Matrix  [][]int16 `bin:"len:2,[len:NameLen-1,[le]]"`
+	Inner   struct {
+		Len  uint8
+		Data []byte `bin:"len:Len*2"`
+		Sum  uint16 `bin:"ReadSum"`
+	}
+	Tail    uint8 `bin:"offsetEnd:-1,offsetRestore"`
+	Next    uint8
+	_       [2]byte
+	skipped struct {
+		Len  uint8
+		Data []byte `bin:"len:Len"`
+	}
+	Skip   []byte `bin:"-"`
+	F32    float32
+	F64    float64
+	B      bool
+	Custom uint16   `bin:"ReadCustom"`
+	Values []uint16 `bin:"len:2,[ReadValue]"`
+	Date   Date
+	Dates  [2]Date `bin:"be"`
+}
+
+func (h *Header) ReadSum(r binstruct.Reader) (uint16, error) {
+	return r.WithOrder(binary.LittleEndian).ReadUint16()
+}
+
+func (h *Header) ReadCustom(r binstruct.Reader) error {
+	v, err := r.ReadUint8()
+	h.Custom = uint16(v) + 1
+	return err
+}
+
+func (h *Header) ReadValue(r binstruct.Reader) (uint16, error) {
+	v, err := r.ReadUint8()
+	return uint16(v) * 2, err
+}
+
+type Entry struct {
+	ID    uint16
+	Value int64 `bin:"len:5"`
+	Kind  Kind
+}
+
+type Kind uint8
+
+// Date is decoded by its own method.
+type Date struct {
+	Year  uint16
+	Month uint8
+	Day   uint8
+}
+
+func (d *Date) UnmarshalBinstruct(r binstruct.Reader) error {
+	v, err := r.ReadUint16()
+	if err != nil {
+		return err
+	}
+
+	d.Year = v>>9 + 1980
+	d.Month = uint8(v >> 5 & 0x0f)
+	d.Day = uint8(v & 0x1f)
+	return nil
+}
@@ -0,0 +1,99 @@
+package fixture
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ghostiam/binstruct"
+	"github.com/stretchr/testify/require"
+)
+
+// plainHeader has the same fields as Header, but without the generated method,
+// so it is decoded by reflection.
+type plainHeader Header
+
+func (h *plainHeader) ReadSum(r binstruct.Reader) (uint16, error) {
+	return (*Header)(h).ReadSum(r)
+}
+
+func (h *plainHeader) ReadCustom(r binstruct.Reader) error {
+	return (*Header)(h).ReadCustom(r)
+}
+
+func (h *plainHeader) ReadValue(r binstruct.Reader) (uint16, error) {
+	return (*Header)(h).ReadValue(r)
+}
+
+type plainEntry Entry
+
+var headerData = []byte{
+	'B', 'I', 'N', 0x00, // Magic
+	0x02, 0x00, // Version
+	0x03,          // NameLen
+	'a', 'b', 'c', // Name
+	0x00, 0x00, 0x02, // Count
+	0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x0a, 0x01, // Entries[0]
+	0x00, 0x02, 0xff, 0xff, 0xff, 0xff, 0xf6, 0x02, // Entries[1]
+	0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04, 0x00, // Matrix
+	0x02, 0x01, 0x02, 0x03, 0x04, // Inner.Len, Inner.Data
+	0x34, 0x12, // Inner.Sum
+	0x10,       // Next
+	0x00, 0x00, // _
+	0x01,                   // skipped.Len, the struct is discarded, so no data is read
+	0x40, 0x49, 0x0f, 0xdb, // F32
+	0x40, 0x09, 0x21, 0xfb, 0x54, 0x44, 0x2d, 0x18, // F64
+	0x01,       // B
+	0x04,       // Custom
+	0x01, 0x02, // Values
+	0x52, 0x21, // Date
+	0x52, 0x21, 0x52, 0x22, // Dates
+	0xff, // Tail
+}
+
+func TestHeader(t *testing.T) {
+	var actual Header
+	err := binstruct.UnmarshalBE(headerData, &actual)
+	require.NoError(t, err)
+
+	var expected plainHeader
+	err = binstruct.UnmarshalBE(headerData, &expected)
+	require.NoError(t, err)
+
+	require.Equal(t, Header(expected), actual)
+	require.Equal(t, "abc", actual.Name)
+	require.Equal(t, uint16(0x1234), actual.Inner.Sum)
+	require.Equal(t, uint8(0xff), actual.Tail)
+	require.Equal(t, Date{Year: 2021, Month: 1, Day: 2}, actual.Dates[1])
+}
+
+func TestHeaderErrors(t *testing.T) {
+	for n := 0; n < len(headerData); n++ {
+		var actual Header
+		err := binstruct.UnmarshalBE(headerData[:n], &actual)
+
+		var expected plainHeader
+		expectedErr := binstruct.UnmarshalBE(headerData[:n], &expected)
+
+		if expectedErr == nil {
+			require.NoError(t, err, "len %d", n)
+		} else {
+			// The messages contain the type name of the struct with the methods.
+			require.EqualError(t, err, strings.ReplaceAll(expectedErr.Error(), "plainHeader", "Header"), "len %d", n)
+		}
+		require.Equal(t, Header(expected), actual, "len %d", n)
+	}
+}
+
+func TestEntry(t *testing.T) {
+	data := []byte{0x00, 0x01, 0xff, 0xff, 0xff, 0xff, 0xf6, 0x02}
+
+	var actual Entry
+	err := binstruct.UnmarshalLE(data, &actual)
+	require.NoError(t, err)
+
+	var expected plainEntry
+	err = binstruct.UnmarshalLE(data, &expected)
+	require.NoError(t, err)
+
+	require.Equal(t, Entry(expected), actual)
+}
@@ -0,0 +1,142 @@
+// Binstructgen generates UnmarshalBinstruct methods for structs with bin tags.
+// The generated methods decode the same bytes in the same way as
+// binstruct.Unmarshal, but without reflection.
+//
+// Usage:
+//
+//	//go:generate go run github.com/ghostiam/binstruct/cmd/binstructgen -type=Header,Entry
+//
+// Flags:
+//
+//	-type    comma-separated list of struct type names, required
+//	-output  output file name, default is <type>_binstruct.go
+//
+// The package directory is the current directory, or the first argument.
+// Nested struct types are decoded inline, unless they implement
+// binstruct.Unmarshaler or are listed in -type.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of struct type names, required")
+	output    = flag.String("output", "", "output file name, default is <type>_binstruct.go")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of binstructgen:\n")
+	fmt.Fprintf(os.Stderr, "\tbinstructgen -type=T[,T...] [-output=file] [directory]\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("binstructgen: ")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	names := strings.Split(*typeNames, ",")
+
+	outputName := *output
+	if outputName == "" {
+		outputName = strings.ToLower(names[0]) + "_binstruct.go"
+	}
+	outputName = filepath.Join(dir, outputName)
+
+	src, err := generate(dir, filepath.Base(outputName), names)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = os.WriteFile(outputName, src, 0o644)
+	if err != nil {
+		log.Fatalf("write output: %s", err)
+	}
+}
+
+// generate returns the source of UnmarshalBinstruct methods for the types of the package in dir.
+// The previously generated file is skipped, so the methods are generated from scratch.
+func generate(dir, outputName string, typeNames []string) ([]byte, error) {
+	pkg, err := loadPackage(dir, outputName)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGenerator(pkg).Generate(typeNames)
+}
+
+func loadPackage(dir, skipFile string) (*types.Package, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	fset := token.NewFileSet()
+
+	var files []*ast.File
+	for _, name := range matches {
+		base := filepath.Base(name)
+		if base == skipFile || strings.HasSuffix(base, "_test.go") {
+			continue
+		}
+
+		ok, err := build.Default.MatchFile(dir, base)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		// The package may use the methods that are not generated yet.
+		Error: func(error) {},
+	}
+
+	pkg, err := conf.Check(files[0].Name.Name, fset, files, nil)
+	if pkg == nil {
+		return nil, fmt.Errorf("type check: %w", err)
+	}
+
+	return pkg, nil
+}
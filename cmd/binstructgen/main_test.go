@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateFixture(t *testing.T) {
+	expected, err := os.ReadFile("internal/fixture/fixture_binstruct.go")
+	require.NoError(t, err)
+
+	actual, err := generate("internal/fixture", "fixture_binstruct.go", []string{"Header", "Entry"})
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(actual), "run go generate ./...")
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name:    "not found",
+			src:     `type Other struct{}`,
+			wantErr: `type "T" not found`,
+		},
+		{
+			name:    "not struct",
+			src:     `type T int`,
+			wantErr: `type "T" is not a struct`,
+		},
+		{
+			name:    "unsupported type",
+			src:     `type T struct { M map[string]int }`,
+			wantErr: `type "T": field "M": type "map" not supported`,
+		},
+		{
+			name:    "unsupported pointer",
+			src:     `type T struct { P *uint8 }`,
+			wantErr: `type "T": field "P": type "ptr" not supported`,
+		},
+		{
+			name:    "unsupported map tags",
+			src:     "type T struct { M map[string]uint8 `bin:\"len:2, [key:cstring][val:le]\"` }",
+			wantErr: `type "T": field "M": tag "key" is not supported`,
+		},
+		{
+			name:    "unsupported tag",
+			src:     "type T struct { A uint8 `bin:\"foo:1\"` }",
+			wantErr: `type "T": field "A": tag "foo" is not supported`,
+		},
+		{
+			name:    "unsupported cstring",
+			src:     "type T struct { S string `bin:\"cstring\"` }",
+			wantErr: `type "T": field "S": tag "cstring" is not supported`,
+		},
+		{
+			name:    "unsupported len:*",
+			src:     "type T struct { S []byte `bin:\"len:*\"` }",
+			wantErr: `type "T": field "S": tag "len:*" is not supported`,
+		},
+		{
+			name:    "unsupported bits",
+			src:     "type T struct { A uint8 `bin:\"bits:3,lsb\"` }",
+			wantErr: `type "T": field "A": tag "bits" is not supported`,
+		},
+		{
+			name:    "unsupported uvarint",
+			src:     "type T struct { A uint32 `bin:\"uvarint\"` }",
+			wantErr: `type "T": field "A": tag "uvarint" is not supported`,
+		},
+		{
+			name:    "unsupported comparison",
+			src:     "type T struct { A uint8; B []byte `bin:\"len:A > 2\"` }",
+			wantErr: `type "T": failed parse ReadData from tags for field "B": expression "A > 2": operator ">" on fields is not supported`,
+		},
+		{
+			name:    "unsupported parent field",
+			src:     "type T struct { B []byte `bin:\"len:../A\"` }",
+			wantErr: `type "T": failed parse ReadData from tags for field "B": expression "../A": variables and fields of parent structs are not supported`,
+		},
+		{
+			name:    "unsupported function",
+			src:     "type T struct { A uint8; B []byte `bin:\"len:min(A, 2)\"` }",
+			wantErr: `type "T": failed parse ReadData from tags for field "B": expression "min(A, 2)": functions are not supported`,
+		},
+		{
+			name:    "unsupported method arguments",
+			src:     "type T struct { A uint8; B []byte `bin:\"ReadB(A, 2)\"` }",
+			wantErr: `type "T": field "B": arguments of method "ReadB(A, 2)" are not supported`,
+		},
+		{
+			name:    "unknown field",
+			src:     "type T struct { A []byte `bin:\"len:Len\"` }",
+			wantErr: `type "T": failed parse ReadData from tags for field "A": can't get field len from "Len" field`,
+		},
+		{
+			name:    "without len",
+			src:     `type T struct { S string }`,
+			wantErr: `type "T": field "S": need set tag with len for string`,
+		},
+		{
+			name: "method not exist",
+			src:  "type T struct { A uint8 `bin:\"ReadA\"` }",
+			wantErr: `type "T": field "A": 
+failed call method, expected methods:
+	func (*T) ReadA(r binstruct.Reader) error {}
+or
+	func (*T) ReadA(r binstruct.Reader) (uint8, error) {}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := os.WriteFile(filepath.Join(dir, "t.go"), []byte("package p\n\n"+tt.src+"\n"), 0o644)
+			require.NoError(t, err)
+
+			_, err = generate(dir, "t_binstruct.go", []string{"T"})
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestDecimalLiterals(t *testing.T) {
+	tests := map[string]string{
+		"010":             "10",
+		"Len*010 + 00":    "Len*10 + 0",
+		"0x10 + 0o10 + 0": "0x10 + 0o10 + 0",
+		"A09 + 1.05":      "A09 + 1.05",
+	}
+
+	for v, want := range tests {
+		require.Equal(t, want, decimalLiterals(v), v)
+	}
+}
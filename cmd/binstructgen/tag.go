@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ghostiam/binstruct/internal/bintag"
+)
+
+type fieldOffset struct {
+	Value  string
+	Whence int
+}
+
+// fieldTags is the same as fieldReadData of the binstruct package,
+// but the values are not calculated.
+type fieldTags struct {
+	Ignore        bool
+	Length        *string
+	Offsets       []fieldOffset
+	OffsetRestore bool
+	FuncName      string
+	Order         string // name of the binary.ByteOrder variable
+
+	Elem *fieldTags
+}
+
+func parseFieldTags(tags []bintag.Tag) (*fieldTags, error) {
+	var data fieldTags
+	for _, t := range tags {
+		switch t.Type {
+		case bintag.TypeIgnore:
+			return &fieldTags{Ignore: true}, nil
+
+		case bintag.TypeLength:
+			if strings.TrimSpace(t.Value) == "*" {
+				return nil, fmt.Errorf(`tag "%s:%s" is not supported`, t.Type, t.Value)
+			}
+
+			v := t.Value
+			data.Length = &v
+
+		case bintag.TypeOffsetFromCurrent:
+			data.Offsets = append(data.Offsets, fieldOffset{Value: t.Value, Whence: io.SeekCurrent})
+
+		case bintag.TypeOffsetFromStart:
+			data.Offsets = append(data.Offsets, fieldOffset{Value: t.Value, Whence: io.SeekStart})
+
+		case bintag.TypeOffsetFromEnd:
+			data.Offsets = append(data.Offsets, fieldOffset{Value: t.Value, Whence: io.SeekEnd})
+
+		case bintag.TypeOffsetRestore:
+			data.OffsetRestore = true
+
+		case bintag.TypeFunc:
+			if strings.Contains(t.Value, "(") {
+				return nil, fmt.Errorf(`arguments of method "%s" are not supported`, t.Value)
+			}
+
+			data.FuncName = t.Value
+
+		case bintag.TypeWrite:
+			// Used only for encoding
+
+		case bintag.TypeElement:
+			elem, err := parseFieldTags(t.ElemTags)
+			if err != nil {
+				return nil, err
+			}
+			data.Elem = elem
+
+		case bintag.TypeOrderLE:
+			data.Order = "LittleEndian"
+
+		case bintag.TypeOrderBE:
+			data.Order = "BigEndian"
+
+		default:
+			return nil, fmt.Errorf(`tag "%s" is not supported`, t.Type)
+		}
+	}
+
+	return &data, nil
+}
@@ -1,6 +1,7 @@
 package binstruct_test
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"log"
@@ -127,3 +128,68 @@ func Example_readmeReader() {
 	// Read 4 bytes: []byte{0x7, 0x8, 0x9, 0xa}
 	// Read all: []byte{0xb, 0xc, 0xd, 0xe, 0xf}
 }
+
+func Example_readmeMarshal() {
+	type dataStruct struct {
+		Arr []int16 `bin:"len:4"`
+	}
+
+	data, err := binstruct.MarshalBE(dataStruct{Arr: []int16{1, 2, 3, 4}}) // MarshalLE() or Marshal()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%#v", data)
+
+	// Output:
+	// []byte{0x0, 0x1, 0x0, 0x2, 0x0, 0x3, 0x0, 0x4}
+}
+
+func Example_readmeWriter() {
+	var data []byte
+
+	writer := binstruct.NewWriterToBytes(&data, binary.BigEndian, false)
+
+	err := writer.WriteInt16(258)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = writer.WriteUintX(3, 0x030405)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = writer.WriteBytes([]byte{0x06, 0x07})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%#v\n", data)
+
+	// Output:
+	// []byte{0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7}
+}
+
+func Example_readmeEncoderWithDebuging() {
+	type dataStruct struct {
+		Arr []int16 `bin:"len:4"`
+	}
+
+	var buf bytes.Buffer
+	encoder := binstruct.NewEncoder(&buf, binary.BigEndian)
+	encoder.SetDebug(true) // you can enable the output of bytes written for debugging
+	err := encoder.Encode(dataStruct{Arr: []int16{1, 2, 3, 4}})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%#v", buf.Bytes())
+
+	// Output:
+	// Write(offset: 0|len: 2): 00000000  00 01                                             |..|
+	// Write(offset: 2|len: 2): 00000000  00 02                                             |..|
+	// Write(offset: 4|len: 2): 00000000  00 03                                             |..|
+	// Write(offset: 6|len: 2): 00000000  00 04                                             |..|
+	// []byte{0x0, 0x1, 0x0, 0x2, 0x0, 0x3, 0x0, 0x4}
+}
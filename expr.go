@@ -0,0 +1,1043 @@
+package binstruct
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ghostiam/binstruct/internal/bintag"
+)
+
+var errDivisionByZero = errors.New("division by zero")
+
+var errNilPointer = errors.New("nil pointer")
+
+// tagValue is the compiled value of a tag, such as "42", "DataLength"
+// or "(Inner.DataLength+10)*2". Operators and their precedence are the same as in Go,
+// comparisons and logical operators result in 1 if true and 0 if false.
+type tagValue struct {
+	Raw  string
+	Root exprNode
+}
+
+// exprEnv is the environment the expression is evaluated in.
+type exprEnv struct {
+	Struct  reflect.Value   // struct of the field the tag belongs to
+	Parents []reflect.Value // parent structs, the root struct is the first
+
+	Index    int64 // index of the element, for element tags
+	HasIndex bool
+
+	Stream  io.Seeker // reader or writer, for pos(), size() and remaining()
+	Writing bool      // size of the stream is unknown when writing
+}
+
+// exprNode is the node of the parsed expression.
+type exprNode interface {
+	eval(env *exprEnv) (int64, error)
+}
+
+// exprConst is the integer literal.
+type exprConst struct {
+	Value int64
+}
+
+// exprField is the reference to the field of the struct, of a parent struct
+// ("../Count", "$parent.Count") or of the root struct ("$root.Header.Count").
+type exprField struct {
+	Name  string // as written in the tag
+	Path  string // field name relative to the referenced struct
+	Up    int    // number of levels up to the parent struct
+	Root  bool
+	Index []int // index sequence of the field of the current struct, nil if not found
+
+	Elem []exprElem // indexes of slices and arrays, such as "Sizes[$i]" or "Headers[0].Size"
+}
+
+// exprElem is the index of the slice, array or string, optionally followed by
+// the field of the struct element.
+type exprElem struct {
+	Index exprNode
+	Path  string
+}
+
+// exprCall is the call of the built-in function. The argument of sizeof, offsetof
+// and len is the field, which is not a value the expression depends on.
+type exprCall struct {
+	Func  string
+	Args  []exprNode
+	Field *exprField
+}
+
+// exprFuncs is the number of arguments of built-in functions, -1 if variadic.
+var exprFuncs = map[string]int{
+	"sizeof":    1,
+	"offsetof":  1,
+	"len":       1,
+	"pos":       0,
+	"size":      0,
+	"remaining": 0,
+	"min":       -1,
+	"max":       -1,
+	"align":     2,
+}
+
+// exprVar is the variable, "$i" or "$index" is the index of the element in element tags.
+type exprVar struct {
+	Name string
+}
+
+type exprUnary struct {
+	Op string
+	X  exprNode
+}
+
+type exprBinary struct {
+	Op   string
+	X, Y exprNode
+}
+
+// exprPrecedence is the precedence of binary operators, as in Go.
+var exprPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4, "|": 4, "^": 4,
+	"*": 5, "/": 5, "%": 5, "<<": 5, ">>": 5, "&": 5, "&^": 5,
+}
+
+func compileValue(structType reflect.Type, v string) (*tagValue, error) {
+	v = strings.TrimSpace(v)
+
+	p := &exprParser{structType: structType, src: v}
+	err := p.tokenize()
+	if err != nil {
+		return nil, fmt.Errorf(`invalid expression "%s": %w`, v, err)
+	}
+
+	root, err := p.parseBinary(1)
+	if err == nil && p.peek().Kind != tokenEOF {
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf(`invalid expression "%s": %w`, v, err)
+	}
+
+	return &tagValue{Raw: v, Root: root}, nil
+}
+
+func (tv *tagValue) isConst() bool {
+	return isConstNode(tv.Root)
+}
+
+// usesIndex reports whether the value refers to the element index.
+func (tv *tagValue) usesIndex() bool {
+	var found bool
+	walk(tv.Root, func(n exprNode) {
+		if _, ok := n.(*exprVar); ok {
+			found = true
+		}
+	})
+
+	return found
+}
+
+// usesEnd reports whether the value refers to the end of the input with size()
+// or remaining(), which is not known when writing.
+func (tv *tagValue) usesEnd() bool {
+	var found bool
+	walk(tv.Root, func(n exprNode) {
+		if c, ok := n.(*exprCall); ok && (c.Func == "size" || c.Func == "remaining") {
+			found = true
+		}
+	})
+
+	return found
+}
+
+func (tv *tagValue) eval(env *exprEnv) (int64, error) {
+	v, err := tv.Root.eval(env)
+	if errors.Is(err, errDivisionByZero) {
+		return 0, fmt.Errorf(`%w in "%s"`, err, tv.Raw)
+	}
+
+	return v, err
+}
+
+// evalValues evaluates the values, such as arguments of the method.
+func evalValues(env *exprEnv, values []*tagValue) ([]int64, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	result := make([]int64, len(values))
+	for i, v := range values {
+		var err error
+		result[i], err = v.eval(env)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// compileMethodCall compiles the method name of the tag and its optional arguments,
+// such as "ReadEntries" or "ReadEntries(Count, 4)".
+func compileMethodCall(structType reflect.Type, v string) (string, []*tagValue, error) {
+	v = strings.TrimSpace(v)
+
+	open := strings.IndexByte(v, '(')
+	if open == -1 {
+		return v, nil, nil
+	}
+
+	if !strings.HasSuffix(v, ")") {
+		return "", nil, fmt.Errorf(`invalid method call "%s": missing ")"`, v)
+	}
+
+	name := strings.TrimSpace(v[:open])
+	args := v[open+1 : len(v)-1]
+
+	var values []*tagValue
+	for strings.TrimSpace(args) != "" {
+		arg := args
+		if i := bintag.IndexComma(args); i != -1 {
+			arg, args = args[:i], args[i+1:]
+		} else {
+			args = ""
+		}
+
+		value, err := compileValue(structType, arg)
+		if err != nil {
+			return "", nil, fmt.Errorf(`invalid method call "%s": %w`, v, err)
+		}
+
+		values = append(values, value)
+	}
+
+	return name, values, nil
+}
+
+// invert solves the value for the single field it refers to, so that
+// the value is equal to result. If the value does not refer to any field,
+// operand is nil.
+func (tv *tagValue) invert(env *exprEnv, result int64) (operand *exprField, value int64, err error) {
+	var count int
+	walk(tv.Root, func(n exprNode) {
+		if _, ok := n.(*exprField); ok {
+			count++
+		}
+	})
+
+	switch count {
+	case 0:
+		return nil, 0, nil
+	case 1:
+		return invertNode(env, tv.Raw, tv.Root, result)
+	default:
+		return nil, 0, fmt.Errorf(`cannot invert "%s": refers to more than one field`, tv.Raw)
+	}
+}
+
+func invertNode(env *exprEnv, raw string, n exprNode, result int64) (*exprField, int64, error) {
+	switch n := n.(type) {
+	case *exprField:
+		if len(n.Elem) > 0 {
+			return nil, 0, fmt.Errorf(`cannot invert "%s": element of "%s" can't be set`, raw, n.Name)
+		}
+
+		return n, result, nil
+
+	case *exprUnary:
+		switch n.Op {
+		case "+":
+			return invertNode(env, raw, n.X, result)
+		case "-":
+			return invertNode(env, raw, n.X, -result)
+		case "^":
+			return invertNode(env, raw, n.X, ^result)
+		}
+
+		return nil, 0, fmt.Errorf(`cannot invert "%s": operator "%s" is not invertible`, raw, n.Op)
+
+	case *exprBinary:
+		// The other operand has no fields, but it can be a call, such as sizeof(Header) or pos().
+		fieldLeft := hasField(n.X)
+		node, other := n.X, n.Y
+		if !fieldLeft {
+			node, other = n.Y, n.X
+		}
+
+		c, err := other.eval(env)
+		if err != nil {
+			return nil, 0, fmt.Errorf(`cannot invert "%s": %w`, raw, err)
+		}
+
+		switch n.Op {
+		case "+":
+			return invertNode(env, raw, node, result-c)
+		case "-":
+			if fieldLeft {
+				return invertNode(env, raw, node, result+c)
+			}
+			return invertNode(env, raw, node, c-result)
+		case "^":
+			return invertNode(env, raw, node, result^c)
+		case "*":
+			if c == 0 || result%c != 0 {
+				return nil, 0, fmt.Errorf(`cannot invert "%s": %d is not a multiple of %d`, raw, result, c)
+			}
+			return invertNode(env, raw, node, result/c)
+		case "/", "%":
+			return nil, 0, fmt.Errorf(`cannot invert "%s": division is not invertible`, raw)
+		}
+
+		return nil, 0, fmt.Errorf(`cannot invert "%s": operator "%s" is not invertible`, raw, n.Op)
+	}
+
+	return nil, 0, fmt.Errorf(`cannot invert "%s"`, raw)
+}
+
+// walk calls fn for each node of the expression.
+func walk(n exprNode, fn func(exprNode)) {
+	fn(n)
+
+	switch n := n.(type) {
+	case *exprField:
+		for _, e := range n.Elem {
+			walk(e.Index, fn)
+		}
+	case *exprCall:
+		for _, arg := range n.Args {
+			walk(arg, fn)
+		}
+	case *exprUnary:
+		walk(n.X, fn)
+	case *exprBinary:
+		walk(n.X, fn)
+		walk(n.Y, fn)
+	}
+}
+
+// hasField reports whether the expression refers to a field.
+func hasField(n exprNode) bool {
+	var found bool
+	walk(n, func(n exprNode) {
+		if _, ok := n.(*exprField); ok {
+			found = true
+		}
+	})
+
+	return found
+}
+
+// isConstNode reports whether the expression refers to no fields, variables and the stream.
+func isConstNode(n exprNode) bool {
+	isConst := true
+	walk(n, func(n exprNode) {
+		switch n := n.(type) {
+		case *exprField, *exprVar:
+			isConst = false
+		case *exprCall:
+			if n.Field != nil || len(n.Args) == 0 {
+				isConst = false
+			}
+		}
+	})
+
+	return isConst
+}
+
+func (e *exprConst) eval(*exprEnv) (int64, error) {
+	return e.Value, nil
+}
+
+// isLocal reports whether the field belongs to the current struct.
+func (e *exprField) isLocal() bool {
+	return e.Up == 0 && !e.Root
+}
+
+func (e *exprField) eval(env *exprEnv) (int64, error) {
+	lenVal, err := e.value(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch lenVal.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return lenVal.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(lenVal.Uint()), nil
+	case reflect.Bool:
+		return boolInt(lenVal.Bool()), nil
+	default:
+		return 0, errors.New("can't get field len from \"" + e.Name + "\" field")
+	}
+}
+
+// value returns the value of the field, or of its element if the field is indexed.
+func (e *exprField) value(env *exprEnv) (reflect.Value, error) {
+	structValue, err := e.structValue(env)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	index := e.Index
+	if !e.isLocal() {
+		index = fieldIndex(structValue.Type(), e.Path)
+	}
+
+	if index == nil {
+		return reflect.Value{}, errors.New("can't get field len from \"" + e.Name + "\" field")
+	}
+
+	v, err := fieldByIndex(structValue, index)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf(`can't get field "%s": %w`, e.Name, err)
+	}
+
+	v, err = indirect(v, e.Name)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	for _, elem := range e.Elem {
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array, reflect.String:
+		default:
+			return reflect.Value{}, fmt.Errorf(`can't index %s in "%s"`, v.Type(), e.Name)
+		}
+
+		i, err := elem.Index.eval(env)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		if i < 0 || i >= int64(v.Len()) {
+			return reflect.Value{}, fmt.Errorf(`index %d out of range [0:%d] in "%s"`, i, v.Len(), e.Name)
+		}
+
+		v, err = indirect(v.Index(int(i)), e.Name)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		if elem.Path != "" {
+			var index []int
+			if v.Kind() == reflect.Struct {
+				index = fieldIndex(v.Type(), elem.Path)
+			}
+			if index == nil {
+				return reflect.Value{}, fmt.Errorf(`can't get field "%s" of element in "%s"`, elem.Path, e.Name)
+			}
+
+			v, err = fieldByIndex(v, index)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf(`can't get field "%s": %w`, e.Name, err)
+			}
+
+			v, err = indirect(v, e.Name)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+		}
+	}
+
+	return v, nil
+}
+
+// fieldByIndex returns the nested field by the index sequence,
+// like reflect.Value.FieldByIndex, but returns an error on nil pointers.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, errNilPointer
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+
+	return v, nil
+}
+
+// indirect returns the value the pointer v points to, or v if it is not a pointer.
+func indirect(v reflect.Value, name string) (reflect.Value, error) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}, fmt.Errorf(`can't get field "%s": %w`, name, errNilPointer)
+		}
+
+		v = v.Elem()
+	}
+
+	return v, nil
+}
+
+// structValue returns the struct the field belongs to.
+func (e *exprField) structValue(env *exprEnv) (reflect.Value, error) {
+	switch {
+	case e.Root && len(env.Parents) > 0:
+		return env.Parents[0], nil
+	case e.Up > len(env.Parents):
+		return reflect.Value{}, errors.New("can't get field \"" + e.Name + "\": no parent struct")
+	case e.Up > 0:
+		return env.Parents[len(env.Parents)-e.Up], nil
+	default:
+		return env.Struct, nil
+	}
+}
+
+func (e *exprCall) eval(env *exprEnv) (int64, error) {
+	switch e.Func {
+	case "sizeof", "offsetof":
+		structValue, err := e.Field.structValue(env)
+		if err != nil {
+			return 0, err
+		}
+
+		if e.Func == "sizeof" {
+			return sizeofField(structValue.Type(), e.Field.Path)
+		}
+		return offsetofField(structValue.Type(), e.Field.Path)
+
+	case "len":
+		v, err := e.Field.value(env)
+		if err != nil {
+			return 0, err
+		}
+
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array, reflect.String, reflect.Map:
+			return int64(v.Len()), nil
+		default:
+			return 0, fmt.Errorf(`can't get len of %s in "%s"`, v.Type(), e.Field.Name)
+		}
+
+	case "pos", "size", "remaining":
+		return e.evalStream(env)
+	}
+
+	args := make([]int64, len(e.Args))
+	for i, arg := range e.Args {
+		var err error
+		args[i], err = arg.eval(env)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	switch e.Func {
+	case "min", "max":
+		v := args[0]
+		for _, arg := range args[1:] {
+			if e.Func == "min" && arg < v || e.Func == "max" && arg > v {
+				v = arg
+			}
+		}
+		return v, nil
+
+	default: // "align"
+		v, n := args[0], args[1]
+		if n <= 0 {
+			return 0, fmt.Errorf("align %d is not positive", n)
+		}
+
+		if r := v % n; r > 0 {
+			v += n - r
+		} else if r < 0 {
+			v -= r
+		}
+		return v, nil
+	}
+}
+
+// evalStream returns the current position, the size of the stream or the number of bytes remaining.
+func (e *exprCall) evalStream(env *exprEnv) (int64, error) {
+	if env.Stream == nil {
+		return 0, fmt.Errorf("%s() is not available", e.Func)
+	}
+
+	pos, err := env.Stream.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, fmt.Errorf("%s(): %w", e.Func, err)
+	}
+
+	if e.Func == "pos" {
+		return pos, nil
+	}
+
+	if env.Writing {
+		return 0, fmt.Errorf("%s() is not available when writing", e.Func)
+	}
+
+	size, err := env.Stream.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("%s(): %w", e.Func, err)
+	}
+
+	_, err = env.Stream.Seek(pos, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("%s(): %w", e.Func, err)
+	}
+
+	if e.Func == "size" {
+		return size, nil
+	}
+	return size - pos, nil
+}
+
+func (e *exprVar) eval(env *exprEnv) (int64, error) {
+	if !env.HasIndex {
+		return 0, fmt.Errorf(`"%s" is available only in element tags`, e.Name)
+	}
+
+	return env.Index, nil
+}
+
+func (e *exprUnary) eval(env *exprEnv) (int64, error) {
+	x, err := e.X.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch e.Op {
+	case "-":
+		return -x, nil
+	case "^":
+		return ^x, nil
+	case "!":
+		return boolInt(x == 0), nil
+	default: // "+"
+		return x, nil
+	}
+}
+
+func (e *exprBinary) eval(env *exprEnv) (int64, error) {
+	x, err := e.X.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	// The right operand of logical operators is evaluated only if needed.
+	switch {
+	case e.Op == "&&" && x == 0:
+		return 0, nil
+	case e.Op == "||" && x != 0:
+		return 1, nil
+	}
+
+	y, err := e.Y.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch e.Op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		if y == 0 {
+			return 0, errDivisionByZero
+		}
+		return x / y, nil
+	case "%":
+		if y == 0 {
+			return 0, errDivisionByZero
+		}
+		return x % y, nil
+	case "&":
+		return x & y, nil
+	case "|":
+		return x | y, nil
+	case "^":
+		return x ^ y, nil
+	case "&^":
+		return x &^ y, nil
+	case "<<", ">>":
+		if y < 0 {
+			return 0, fmt.Errorf("negative shift count %d", y)
+		}
+		if e.Op == "<<" {
+			return x << uint64(y), nil
+		}
+		return x >> uint64(y), nil
+	case "==":
+		return boolInt(x == y), nil
+	case "!=":
+		return boolInt(x != y), nil
+	case "<":
+		return boolInt(x < y), nil
+	case "<=":
+		return boolInt(x <= y), nil
+	case ">":
+		return boolInt(x > y), nil
+	case ">=":
+		return boolInt(x >= y), nil
+	default: // "&&", "||"
+		return boolInt(y != 0), nil
+	}
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+const (
+	tokenEOF = iota
+	tokenNumber
+	tokenIdent
+	tokenSelector // the field of the struct element, such as ".Size" of "Headers[0].Size"
+	tokenOp
+)
+
+type exprToken struct {
+	Kind int
+	Text string
+	Pos  int
+}
+
+// exprParser parses the expression by precedence climbing.
+type exprParser struct {
+	structType reflect.Type
+	src        string
+	tokens     []exprToken
+	pos        int
+}
+
+func (p *exprParser) tokenize() error {
+	src := p.src
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+
+		case isDigit(c):
+			start := i
+			for i < len(src) && (isLetter(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			p.tokens = append(p.tokens, exprToken{Kind: tokenNumber, Text: src[start:i], Pos: start})
+
+		case c == '.' && i+1 < len(src) && isLetter(src[i+1]):
+			start := i
+			i++
+			for i < len(src) && (isLetter(src[i]) || isDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			p.tokens = append(p.tokens, exprToken{Kind: tokenSelector, Text: src[start:i], Pos: start})
+
+		case isLetter(c), c == '$', strings.HasPrefix(src[i:], "../"):
+			start := i
+			for strings.HasPrefix(src[i:], "../") {
+				i += len("../")
+			}
+			if i < len(src) && src[i] == '$' && i == start {
+				i++
+			}
+			if i == len(src) || !isLetter(src[i]) {
+				return fmt.Errorf(`unexpected "%s" at %d`, src[start:i], start)
+			}
+
+			for i < len(src) && (isLetter(src[i]) || isDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			p.tokens = append(p.tokens, exprToken{Kind: tokenIdent, Text: src[start:i], Pos: start})
+
+		default:
+			op := ""
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "<<", ">>", "&^", "==", "!=", "<=", ">=", "&&", "||":
+					op = two
+				}
+			}
+
+			if op == "" && strings.IndexByte("+-*/%&|^<>!()[],", c) != -1 {
+				op = string(c)
+			}
+
+			if op == "" {
+				return fmt.Errorf(`unexpected "%c" at %d`, c, i)
+			}
+
+			p.tokens = append(p.tokens, exprToken{Kind: tokenOp, Text: op, Pos: i})
+			i += len(op)
+		}
+	}
+
+	p.tokens = append(p.tokens, exprToken{Kind: tokenEOF, Pos: len(src)})
+	return nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.Kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) unexpected() error {
+	t := p.peek()
+	if t.Kind == tokenEOF {
+		return errors.New("unexpected end")
+	}
+
+	return fmt.Errorf(`unexpected "%s" at %d`, t.Text, t.Pos)
+}
+
+func (p *exprParser) parseBinary(minPrecedence int) (exprNode, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		precedence, ok := exprPrecedence[t.Text]
+		if t.Kind != tokenOp || !ok || precedence < minPrecedence {
+			return x, nil
+		}
+		p.next()
+
+		y, err := p.parseBinary(precedence + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		x = &exprBinary{Op: t.Text, X: x, Y: y}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	t := p.peek()
+	if t.Kind == tokenOp {
+		switch t.Text {
+		case "+", "-", "!", "^":
+			p.next()
+
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+
+			return &exprUnary{Op: t.Text, X: x}, nil
+		}
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.peek()
+	switch {
+	case t.Kind == tokenNumber:
+		p.next()
+
+		v, err := parseNumber(t.Text)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid number "%s" at %d`, t.Text, t.Pos)
+		}
+
+		return &exprConst{Value: v}, nil
+
+	case t.Kind == tokenIdent:
+		p.next()
+
+		if next := p.peek(); next.Kind == tokenOp && next.Text == "(" {
+			return p.parseCall(t)
+		}
+
+		return p.parseField(t)
+
+	case t.Kind == tokenOp && t.Text == "(":
+		p.next()
+
+		x, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+
+		if t := p.peek(); t.Kind != tokenOp || t.Text != ")" {
+			return nil, p.unexpected()
+		}
+		p.next()
+
+		return x, nil
+	}
+
+	return nil, p.unexpected()
+}
+
+// parseCall parses the call of the built-in function named by the token t.
+func (p *exprParser) parseCall(t exprToken) (exprNode, error) {
+	numArgs, ok := exprFuncs[t.Text]
+	if !ok {
+		return nil, fmt.Errorf(`unknown function "%s" at %d`, t.Text, t.Pos)
+	}
+	p.next()
+
+	call := &exprCall{Func: t.Text}
+	for {
+		if next := p.peek(); next.Kind == tokenOp && next.Text == ")" && len(call.Args) == 0 {
+			p.next()
+			break
+		}
+
+		arg, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+
+		next := p.peek()
+		if next.Kind != tokenOp || next.Text != "," && next.Text != ")" {
+			return nil, p.unexpected()
+		}
+		p.next()
+
+		if next.Text == ")" {
+			break
+		}
+	}
+
+	if numArgs >= 0 && len(call.Args) != numArgs || numArgs < 0 && len(call.Args) == 0 {
+		return nil, fmt.Errorf("wrong number of arguments of %s() at %d", t.Text, t.Pos)
+	}
+
+	switch call.Func {
+	case "sizeof", "offsetof", "len":
+		f, ok := call.Args[0].(*exprField)
+		if !ok || call.Func != "len" && len(f.Elem) > 0 {
+			return nil, fmt.Errorf("argument of %s() at %d must be a field", t.Text, t.Pos)
+		}
+
+		call.Field, call.Args = f, nil
+	}
+
+	return call, nil
+}
+
+// parseField parses the field or the variable of the token t, and the indexes of the field.
+func (p *exprParser) parseField(t exprToken) (exprNode, error) {
+	x, err := p.field(t)
+	if err != nil {
+		return nil, err
+	}
+
+	f, ok := x.(*exprField)
+	if !ok {
+		return x, nil
+	}
+
+	for p.peek().Kind == tokenOp && p.peek().Text == "[" {
+		p.next()
+
+		index, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+
+		end := p.peek()
+		if end.Kind != tokenOp || end.Text != "]" {
+			return nil, p.unexpected()
+		}
+		p.next()
+
+		elem := exprElem{Index: index}
+		if path := p.peek(); path.Kind == tokenSelector {
+			p.next()
+			elem.Path = path.Text[1:]
+			end = path
+		}
+
+		f.Elem = append(f.Elem, elem)
+		f.Name = p.src[t.Pos : end.Pos+len(end.Text)]
+	}
+
+	return f, nil
+}
+
+// field returns the reference to the field of the struct, a parent struct or the root struct.
+func (p *exprParser) field(t exprToken) (exprNode, error) {
+	f := &exprField{Name: t.Text, Path: t.Text}
+	for strings.HasPrefix(f.Path, "../") {
+		f.Path = f.Path[len("../"):]
+		f.Up++
+	}
+
+	if strings.HasPrefix(f.Path, "$") {
+		v, path, _ := strings.Cut(f.Path, ".")
+		switch v {
+		case "$root":
+			f.Root = true
+		case "$parent":
+			f.Up = 1
+		case "$i", "$index":
+			if path != "" {
+				return nil, fmt.Errorf(`unexpected "%s" at %d`, t.Text, t.Pos)
+			}
+			return &exprVar{Name: v}, nil
+		default:
+			return nil, fmt.Errorf(`unknown variable "%s" at %d`, v, t.Pos)
+		}
+
+		if path == "" {
+			return nil, fmt.Errorf(`missing field name after "%s" at %d`, v, t.Pos)
+		}
+		f.Path = path
+	}
+
+	if f.isLocal() {
+		f.Index = fieldIndexByName(p.structType, f.Path)
+	}
+
+	return f, nil
+}
+
+// parseNumber parses the integer literal: decimal, or hex, octal and binary with 0x, 0o and 0b
+// prefixes. Unlike Go, leading zeros are decimal. Values greater than max int64 are stored as two's complement.
+func parseNumber(v string) (int64, error) {
+	// Numbers with leading zeros are decimal, as they were before the prefixes were supported.
+	base := 0
+	if len(v) > 1 && v[0] == '0' && isDigit(v[1]) {
+		base = 10
+	}
+
+	i, err := strconv.ParseInt(v, base, 64)
+	if err == nil {
+		return i, nil
+	}
+
+	u, err := strconv.ParseUint(v, base, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(u), nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
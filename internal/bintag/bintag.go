@@ -0,0 +1,212 @@
+// Package bintag parses the bin struct tags. It is shared by the binstruct package
+// and the binstructgen command, so that both read the tags the same way.
+package bintag
+
+import (
+	"errors"
+	"strings"
+)
+
+// Name is the key of the struct tag.
+const Name = "bin"
+
+// Types of tags.
+const (
+	TypeEmpty   = ""
+	TypeIgnore  = "-"
+	TypeFunc    = "func"
+	TypeWrite   = "write"
+	TypeElement = "elem"
+	TypeKey     = "key"
+	TypeValue   = "val"
+
+	TypeOrderLE = "le"
+	TypeOrderBE = "be"
+
+	TypeLength            = "len"
+	TypeOffsetFromCurrent = "offset"
+	TypeOffsetFromStart   = "offsetStart"
+	TypeOffsetFromEnd     = "offsetEnd"
+	TypeOffsetRestore     = "offsetRestore"
+
+	TypeCString = "cstring"
+	TypeTerm    = "term"
+	TypeMax     = "max"
+
+	TypePrefix = "prefix"
+	TypeRest   = "rest"
+
+	TypeUntil    = "until"
+	TypeKeepTerm = "keepTerm"
+
+	TypeSize = "size"
+
+	TypeBits    = "bits"
+	TypeBitsMSB = "msb"
+	TypeBitsLSB = "lsb"
+
+	TypeUvarint = "uvarint"
+	TypeVarint  = "varint"
+	TypeZigzag  = "zigzag"
+	TypeVLQ     = "vlq"
+
+	TypeIf = "if"
+
+	TypeDupKey = "dupKey"
+)
+
+// Tag is the parsed tag, tags in square brackets are ElemTags of
+// the elem, key or val tag.
+type Tag struct {
+	Type  string
+	Value string
+
+	ElemTags []Tag
+}
+
+// Parse parses the value of the struct tag.
+func Parse(t string) ([]Tag, error) {
+	var tags []Tag
+
+	for {
+		var v string
+
+		index := IndexComma(t)
+		switch {
+		case index == -1:
+			v = t
+		default:
+			v = t[:index]
+			t = t[index+1:]
+		}
+
+		v = strings.TrimSpace(v)
+
+		switch {
+		case v == TypeEmpty:
+			// Just skip
+
+		case v == TypeIgnore:
+			tags = append(tags, Tag{Type: TypeIgnore})
+
+		case v == TypeOffsetRestore:
+			tags = append(tags, Tag{Type: TypeOffsetRestore})
+
+		case v == TypeCString:
+			tags = append(tags, Tag{Type: TypeCString})
+
+		case v == TypeRest:
+			tags = append(tags, Tag{Type: TypeRest})
+
+		case v == TypeKeepTerm:
+			tags = append(tags, Tag{Type: TypeKeepTerm})
+
+		case v == TypeBitsMSB:
+			tags = append(tags, Tag{Type: TypeBitsMSB})
+
+		case v == TypeBitsLSB:
+			tags = append(tags, Tag{Type: TypeBitsLSB})
+
+		case v == TypeUvarint, v == TypeVarint, v == TypeZigzag, v == TypeVLQ:
+			tags = append(tags, Tag{Type: v})
+
+		case strings.HasPrefix(v, "["):
+			if index != -1 {
+				v = v + "," + t
+			}
+			var arrBalance int
+			var closeIndex int
+			for {
+				in := v[closeIndex:]
+				idx := strings.IndexAny(in, "[]")
+				closeIndex += idx
+
+				if idx == -1 {
+					return nil, errors.New("unbalanced square bracket")
+				}
+
+				switch in[idx] {
+				case '[':
+					arrBalance--
+				case ']':
+					arrBalance++
+				}
+
+				closeIndex++
+
+				if arrBalance == 0 {
+					break
+				}
+			}
+
+			t = v[closeIndex:]
+			v = v[1 : closeIndex-1]
+
+			// Tags of map keys and values, e.g. "[key:cstring]" or "[val:len:2]".
+			elemType := TypeElement
+			for _, typ := range []string{TypeKey, TypeValue} {
+				if s := strings.TrimSpace(v); strings.HasPrefix(s, typ+":") {
+					elemType, v = typ, s[len(typ)+1:]
+				}
+			}
+
+			pt, err := Parse(v)
+			if err != nil {
+				return nil, err
+			}
+
+			tags = append(tags, Tag{Type: elemType, ElemTags: pt})
+
+			// The closing bracket is followed by the comma, the next bracket or the end of the tag.
+			t = strings.TrimPrefix(strings.TrimSpace(t), ",")
+			if strings.TrimSpace(t) == "" {
+				return tags, nil
+			}
+			continue
+
+		case v == TypeOrderLE:
+			tags = append(tags, Tag{Type: TypeOrderLE})
+
+		case v == TypeOrderBE:
+			tags = append(tags, Tag{Type: TypeOrderBE})
+
+		default:
+			ts := strings.Split(v, ":")
+
+			if len(ts) == 2 {
+				tags = append(tags, Tag{
+					Type:  ts[0],
+					Value: ts[1],
+				})
+			} else {
+				tags = append(tags, Tag{
+					Type:  TypeFunc,
+					Value: v,
+				})
+			}
+		}
+
+		if index == -1 {
+			return tags, nil
+		}
+	}
+}
+
+// IndexComma returns the index of the first comma outside of parentheses, or -1.
+func IndexComma(s string) int {
+	var depth int
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth <= 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,155 @@
+package bintag
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		tag     string
+		want    []Tag
+		wantErr string
+	}{
+		{
+			name: "empty",
+			tag:  "",
+			want: nil,
+		},
+		{
+			name: "ignore",
+			tag:  "-",
+			want: []Tag{{Type: "-"}},
+		},
+		{
+			name: "le",
+			tag:  "le",
+			want: []Tag{{Type: "le"}},
+		},
+		{
+			name: "be",
+			tag:  "be",
+			want: []Tag{{Type: "be"}},
+		},
+		{
+			name: "func",
+			tag:  "TestFunc",
+			want: []Tag{{Type: "func", Value: "TestFunc"}},
+		},
+		{
+			name: "func with write func",
+			tag:  "ReadFunc,write:WriteFunc",
+			want: []Tag{{Type: "func", Value: "ReadFunc"}, {Type: "write", Value: "WriteFunc"}},
+		},
+		{
+			name: "cstring",
+			tag:  "cstring, max:16",
+			want: []Tag{{Type: "cstring"}, {Type: "max", Value: "16"}},
+		},
+		{
+			name: "element ignore",
+			tag:  "[-]",
+			want: []Tag{{Type: "elem", ElemTags: []Tag{{Type: "-"}}}},
+		},
+		{
+			name: "len",
+			tag:  "len:42",
+			want: []Tag{{Type: "len", Value: "42"}},
+		},
+		{
+			name: "offset",
+			tag:  "offset:42",
+			want: []Tag{{Type: "offset", Value: "42"}},
+		},
+		{
+			name: "offsetStart",
+			tag:  "offsetStart:42",
+			want: []Tag{{Type: "offsetStart", Value: "42"}},
+		},
+		{
+			name: "offsetEnd",
+			tag:  "offsetEnd:42",
+			want: []Tag{{Type: "offsetEnd", Value: "42"}},
+		},
+		{
+			name: "multi tag",
+			tag:  "len:1, offset:2, offsetStart:3, offsetEnd:4, offsetRestore",
+			want: []Tag{
+				{Type: "len", Value: "1"},
+				{Type: "offset", Value: "2"},
+				{Type: "offsetStart", Value: "3"},
+				{Type: "offsetEnd", Value: "4"},
+				{Type: "offsetRestore"},
+			},
+		},
+		{
+			name: "multi element",
+			tag:  "[len:1, [len:2, [len3]]], offset:42",
+			want: []Tag{
+				{
+					Type: "elem", Value: "", ElemTags: []Tag{
+						{
+							Type: "len", Value: "1", ElemTags: []Tag(nil),
+						},
+						{
+							Type: "elem", Value: "", ElemTags: []Tag{
+								{
+									Type: "len", Value: "2", ElemTags: []Tag(nil),
+								},
+								{
+									Type: "elem", Value: "", ElemTags: []Tag{
+										{
+											Type: "func", Value: "len3", ElemTags: []Tag(nil),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				{
+					Type: "offset", Value: "42", ElemTags: []Tag(nil),
+				},
+			},
+		},
+		{
+			name: "map key and value",
+			tag:  "len:Count, [key:cstring][val:prefix:uint16], dupKey:last",
+			want: []Tag{
+				{Type: "len", Value: "Count"},
+				{Type: "key", ElemTags: []Tag{{Type: "cstring"}}},
+				{Type: "val", ElemTags: []Tag{{Type: "prefix", Value: "uint16"}}},
+				{Type: "dupKey", Value: "last"},
+			},
+		},
+		{
+			name: "map value with several tags",
+			tag:  "[val:len:2, le]",
+			want: []Tag{{Type: "val", ElemTags: []Tag{{Type: "len", Value: "2"}, {Type: "le"}}}},
+		},
+		{
+			name: "parentheses",
+			tag:  "len:min(A, 2), offset:1",
+			want: []Tag{{Type: "len", Value: "min(A, 2)"}, {Type: "offset", Value: "1"}},
+		},
+		{
+			name:    "unbalanced",
+			tag:     "[",
+			wantErr: "unbalanced square bracket",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.tag)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
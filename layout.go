@@ -0,0 +1,229 @@
+package binstruct
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var errNotStatic = errors.New("depends on the data")
+
+type layoutKey struct {
+	Type   reflect.Type
+	Name   string
+	Offset bool
+}
+
+type layout struct {
+	Value int64
+	Err   error
+}
+
+var layouts sync.Map // map[layoutKey]layout
+
+// sizeofField returns the number of bytes the field occupies, for sizeof.
+// The size must not depend on the data, the tags of the field are taken into account.
+func sizeofField(structType reflect.Type, name string) (int64, error) {
+	return cachedLayout(layoutKey{Type: structType, Name: name})
+}
+
+// offsetofField returns the offset of the field from the start of the struct, for offsetof.
+func offsetofField(structType reflect.Type, name string) (int64, error) {
+	return cachedLayout(layoutKey{Type: structType, Name: name, Offset: true})
+}
+
+func cachedLayout(key layoutKey) (int64, error) {
+	if l, ok := layouts.Load(key); ok {
+		return l.(layout).Value, l.(layout).Err
+	}
+
+	v, err := computeLayout(key)
+	if err != nil {
+		op := "size"
+		if key.Offset {
+			op = "offset"
+		}
+		err = fmt.Errorf(`%s of field "%s" %w`, op, key.Name, err)
+	}
+
+	layouts.Store(key, layout{Value: v, Err: err})
+	return v, err
+}
+
+func computeLayout(key layoutKey) (int64, error) {
+	index := fieldIndexByName(key.Type, key.Name)
+	if index == nil {
+		return 0, errors.New("is not found")
+	}
+
+	var offset int64
+	t := key.Type
+	seen := make(map[reflect.Type]bool)
+	for i, fieldIndex := range index {
+		seen[t] = true
+		plan, err := getStructPlan(t)
+		if err != nil {
+			return 0, err
+		}
+
+		if key.Offset {
+			o, err := structSize(t, plan, fieldIndex, seen)
+			if err != nil {
+				return 0, err
+			}
+			offset += o
+		}
+
+		field := plan.Fields[fieldIndex]
+		fieldType := t.Field(fieldIndex).Type
+		if i == len(index)-1 && !key.Offset {
+			if field.static == nil {
+				return 0, errNotStatic
+			}
+			if field.static.Bits != nil {
+				return 0, errors.New("is a bit field")
+			}
+			return dataSize(fieldType, field.static, seen)
+		}
+
+		t = fieldType
+	}
+
+	return offset, nil
+}
+
+// structSize returns the size of the fields of the struct before the field n,
+// or of all fields if n is negative.
+// The seen set holds the struct types being measured, to stop on types that refer to themselves.
+func structSize(t reflect.Type, plan *structPlan, n int, seen map[reflect.Type]bool) (int64, error) {
+	var size, bits int64
+	for _, field := range plan.Fields {
+		if field.Index == n {
+			if field.static != nil && field.static.Bits != nil {
+				return 0, errors.New("is a bit field")
+			}
+			break
+		}
+
+		data := field.static
+		if data == nil {
+			return 0, errNotStatic
+		}
+
+		fieldType := t.Field(field.Index).Type
+		if data.Bits != nil {
+			n, err := bitsCount(fieldType, *data.Bits)
+			if err != nil {
+				return 0, err
+			}
+
+			bits += int64(n)
+			continue
+		}
+
+		// As in unmarshal, the field starts at the byte boundary.
+		if !data.Ignore && !data.Skip {
+			size, bits = size+(bits+7)/8, 0
+		}
+
+		s, err := dataSize(fieldType, data, seen)
+		if err != nil {
+			return 0, err
+		}
+		size += s
+	}
+
+	return size + (bits+7)/8, nil
+}
+
+// dataSize returns the size of the value of type t with the field read data.
+func dataSize(t reflect.Type, data *fieldReadData, seen map[reflect.Type]bool) (int64, error) {
+	if data == nil {
+		data = noFieldData
+	}
+
+	switch {
+	case data.Ignore, data.Skip:
+		return 0, nil
+	case data.Size != nil:
+		return *data.Size, nil
+	case len(data.Offsets) > 0, data.FuncName != "", data.Varint != "", data.Prefix != "", data.Rest,
+		data.isSentinelTerminated(), data.ElemPlan != nil, isUnmarshaler(t):
+		return 0, errNotStatic
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if data.Length != nil {
+			return *data.Length, nil
+		}
+		if t.Kind() == reflect.Int || t.Kind() == reflect.Uint {
+			return 0, errNotStatic
+		}
+		return int64(t.Size()), nil
+
+	case reflect.Bool, reflect.Float32, reflect.Float64:
+		return int64(t.Size()), nil
+
+	case reflect.Ptr:
+		return dataSize(t.Elem(), data, seen)
+
+	case reflect.String:
+		if data.Length == nil {
+			return 0, errNotStatic
+		}
+		return *data.Length, nil
+
+	case reflect.Slice, reflect.Array:
+		var n int64
+		switch {
+		case data.Length != nil:
+			n = *data.Length
+		case t.Kind() == reflect.Array:
+			n = int64(t.Len())
+		default:
+			return 0, errNotStatic
+		}
+
+		elemSize, err := dataSize(t.Elem(), data.ElemFieldData, seen)
+		if err != nil {
+			return 0, err
+		}
+		return n * elemSize, nil
+
+	case reflect.Map:
+		if data.Length == nil {
+			return 0, errNotStatic
+		}
+
+		keySize, err := dataSize(t.Key(), data.KeyFieldData, seen)
+		if err != nil {
+			return 0, err
+		}
+
+		valueSize, err := dataSize(t.Elem(), data.ValueFieldData, seen)
+		if err != nil {
+			return 0, err
+		}
+		return *data.Length * (keySize + valueSize), nil
+
+	case reflect.Struct:
+		if seen[t] {
+			return 0, errors.New("refers to its own type")
+		}
+
+		plan, err := getStructPlan(t)
+		if err != nil {
+			return 0, err
+		}
+
+		seen[t] = true
+		defer delete(seen, t)
+		return structSize(t, plan, -1, seen)
+
+	default:
+		return 0, errNotStatic
+	}
+}
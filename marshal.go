@@ -0,0 +1,898 @@
+package binstruct
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ghostiam/binstruct/internal/bintag"
+)
+
+type marshal struct {
+	w Writer
+}
+
+// Marshaler is the interface implemented by types that can encode
+// themselves. MarshalBinstruct is called instead of the default encoding
+// for any value of the type, including struct fields and slice elements.
+//
+// The method must not call Marshal on the same type, as it will be
+// called again recursively.
+type Marshaler interface {
+	MarshalBinstruct(w Writer) error
+}
+
+var writerType = reflect.TypeOf((*Writer)(nil)).Elem()
+
+// An InvalidMarshalError describes an invalid argument passed to Marshal.
+// (The argument to Marshal must be a struct or a non-nil pointer to a struct.)
+type InvalidMarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidMarshalError) Error() string {
+	if e.Type == nil {
+		return "binstruct: Marshal(nil)"
+	}
+
+	if e.Type.Kind() == reflect.Ptr && e.Type.Elem().Kind() == reflect.Struct {
+		return "binstruct: Marshal(nil " + e.Type.String() + ")"
+	}
+	return "binstruct: Marshal(non-struct " + e.Type.String() + ")"
+}
+
+func (m *marshal) Marshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Ptr && rv.IsValid() {
+		if !rv.CanAddr() {
+			sv := reflect.New(rv.Type()).Elem()
+			sv.Set(rv)
+			rv = sv
+		}
+
+		if mr, ok := asMarshaler(rv); ok {
+			return mr.MarshalBinstruct(m.w)
+		}
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return &InvalidMarshalError{reflect.TypeOf(v)}
+	}
+
+	return m.marshal(rv, nil)
+}
+
+func (m *marshal) marshal(structValue reflect.Value, parentStructValues []reflect.Value) error {
+	plan, err := getStructPlan(structValue.Type())
+	if err != nil {
+		return err
+	}
+
+	// Work on a copy, so that the length fields can be filled
+	// without modifying the value passed to Marshal. Unexported
+	// embedded structs are always a part of an already copied value.
+	if structValue.CanInterface() && (!structValue.CanAddr() || plan.CopyOnWrite) {
+		sv := reflect.New(structValue.Type()).Elem()
+		sv.Set(structValue)
+		structValue = sv
+	}
+
+	if plan.FillLength {
+		err = fillLengthFields(structValue, parentStructValues, plan)
+		if err != nil {
+			return err
+		}
+	}
+
+	state := &structState{env: exprEnv{Struct: structValue, Parents: parentStructValues, Stream: m.w, Writing: true}}
+
+	// Adjacent bit fields share bytes, any other field starts at the byte boundary.
+	bits := bitWriter{order: plan.BitOrder}
+
+	for _, field := range plan.Fields {
+		fieldData, err := field.readData(&state.env, &state.buf)
+		if err != nil {
+			return fmt.Errorf(`failed parse ReadData from tags for field "%s": %w`, field.Name, err)
+		}
+
+		fieldValue := structValue.Field(field.Index)
+		if fieldData.Bits != nil {
+			err = bits.writeValueFromField(m.w, fieldValue, fieldData)
+		} else {
+			if !fieldData.Ignore && !fieldData.Skip {
+				err = bits.flush(m.w)
+			}
+
+			if err == nil {
+				err = m.writeValueFromField(structValue, fieldValue, fieldData, parentStructValues)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf(`failed write value from field "%s": %w`, field.Name, err)
+		}
+	}
+
+	err = bits.flush(m.w)
+	if err != nil {
+		return fmt.Errorf("failed write bits: %w", err)
+	}
+
+	return nil
+}
+
+func (m *marshal) writeValueFromField(
+	structValue, fieldValue reflect.Value, fieldData *fieldReadData, parentStructValues []reflect.Value,
+) error {
+	if fieldData == nil {
+		fieldData = noFieldData
+	}
+
+	if fieldData.Ignore || fieldData.Skip {
+		return nil
+	}
+
+	w := m.w
+	if fieldData.Order != nil {
+		w = w.WithOrder(fieldData.Order)
+	}
+
+	if fieldData.OffsetRestore {
+		currentOffset, err := w.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("get current offset: %w", err)
+		}
+		defer w.Seek(currentOffset, io.SeekStart)
+	}
+
+	err := setOffset(w, fieldData)
+	if err != nil {
+		return fmt.Errorf("set offset: %w", err)
+	}
+
+	if fieldData.Size != nil {
+		return m.writeSizedValueFromField(structValue, fieldValue, fieldData, parentStructValues)
+	}
+
+	// Unexported and blank fields are skipped on read, so write zeros in their place.
+	// Fields of structs are checked one by one, as embedded structs can be unexported.
+	padding := !fieldValue.CanInterface() && fieldValue.Kind() != reflect.Struct
+	if padding {
+		fieldValue = reflect.New(fieldValue.Type()).Elem()
+	}
+
+	if fieldData.WriteFuncName != "" {
+		var okCallFunc bool
+		okCallFunc, err = callWriteFunc(w, fieldData.WriteFuncName, fieldData.WriteFuncArgs, structValue, fieldValue)
+		if err != nil {
+			return fmt.Errorf("call custom write func(%s): %w", structValue.Type().Name(), err)
+		}
+
+		if !okCallFunc {
+			// Try call function from parent structs
+			for i := len(parentStructValues) - 1; i >= 0; i-- {
+				sv := parentStructValues[i]
+				okCallFunc, err = callWriteFunc(w, fieldData.WriteFuncName, fieldData.WriteFuncArgs, sv, fieldValue)
+				if err != nil {
+					return fmt.Errorf("call custom write func from parent(%s): %w", sv.Type().Name(), err)
+				}
+
+				if okCallFunc {
+					return nil
+				}
+			}
+
+			message := `
+failed call method, expected methods:
+	func (*{{Struct}}) {{MethodName}}(w binstruct.Writer{{Params}}) error {} 
+or
+	func (*{{Struct}}) {{MethodName}}(w binstruct.Writer, v {{FieldType}}{{Params}}) error {}
+`
+			message = strings.NewReplacer(
+				`{{Struct}}`, structValue.Type().Name(),
+				`{{MethodName}}`, fieldData.WriteFuncName,
+				`{{Params}}`, methodParams(len(fieldData.WriteFuncArgs)),
+				`{{FieldType}}`, fieldValue.Type().String(),
+			).Replace(message)
+			return errors.New(message)
+		}
+
+		return nil
+	}
+
+	if fieldData.FuncName != "" {
+		return fmt.Errorf(`need set tag with write method for field with read method %s, e.g. "%s,write:MethodName"`,
+			fieldData.FuncName, fieldData.FuncName)
+	}
+
+	if fieldValue.Kind() == reflect.Ptr {
+		if fieldValue.IsNil() {
+			if !padding {
+				return errors.New(`nil pointer, use the "if" tag for optional fields`)
+			}
+
+			fieldValue = reflect.New(fieldValue.Type().Elem())
+		}
+
+		return m.writeValueFromField(structValue, fieldValue.Elem(), fieldData.pointeeData(), parentStructValues)
+	}
+
+	if mr, ok := asMarshaler(fieldValue); ok {
+		return mr.MarshalBinstruct(w)
+	}
+
+	if fieldData.Bits != nil {
+		return errors.New("bits is supported only for struct fields")
+	}
+
+	if fieldData.Varint != "" {
+		return writeVarintValueFromField(w, fieldValue, fieldData.Varint)
+	}
+
+	if fieldData.Prefix != "" || fieldData.Rest {
+		if fieldValue.Kind() != reflect.String && fieldValue.Kind() != reflect.Slice {
+			if fieldData.Rest {
+				return errors.New("rest is supported only for strings and slices")
+			}
+			return errors.New("prefix is supported only for strings and slices")
+		}
+
+		var length int64
+		if !padding {
+			length = int64(fieldValue.Len())
+		}
+
+		if fieldData.Prefix != "" {
+			err = writePrefix(w, fieldData.Prefix, uint64(length))
+			if err != nil {
+				return fmt.Errorf("write prefix: %w", err)
+			}
+		}
+
+		// The actual length is used as len tag.
+		data := *fieldData
+		data.Length = &length
+		fieldData = &data
+	}
+
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value := fieldValue.Int()
+
+		if fieldData.Length != nil {
+			return w.WriteIntX(int(*fieldData.Length), value)
+		}
+
+		switch fieldValue.Kind() {
+		case reflect.Int8:
+			return w.WriteInt8(int8(value))
+		case reflect.Int16:
+			return w.WriteInt16(int16(value))
+		case reflect.Int32:
+			return w.WriteInt32(int32(value))
+		case reflect.Int64:
+			return w.WriteInt64(value)
+		default: // reflect.Int:
+			return errors.New("need set tag with len or use int8/int16/int32/int64")
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value := fieldValue.Uint()
+
+		if fieldData.Length != nil {
+			return w.WriteUintX(int(*fieldData.Length), value)
+		}
+
+		switch fieldValue.Kind() {
+		case reflect.Uint8:
+			return w.WriteUint8(uint8(value))
+		case reflect.Uint16:
+			return w.WriteUint16(uint16(value))
+		case reflect.Uint32:
+			return w.WriteUint32(uint32(value))
+		case reflect.Uint64:
+			return w.WriteUint64(value)
+		default: // reflect.Uint:
+			return errors.New("need set tag with len or use uint8/uint16/uint32/uint64")
+		}
+	case reflect.Float32:
+		return w.WriteFloat32(float32(fieldValue.Float()))
+	case reflect.Float64:
+		return w.WriteFloat64(fieldValue.Float())
+	case reflect.Bool:
+		return w.WriteBool(fieldValue.Bool())
+	case reflect.String:
+		if fieldData.Term != nil {
+			b := []byte(fieldValue.String())
+			if padding {
+				b = nil
+			}
+
+			return writeTerminated(w, b, fieldData)
+		}
+
+		if fieldData.Length == nil {
+			return errors.New("need set tag with len for string")
+		}
+
+		strLen := int(*fieldData.Length)
+		if strLen < 0 {
+			return ErrNegativeCount
+		}
+
+		b := []byte(fieldValue.String())
+		if padding {
+			b = make([]byte, strLen)
+		}
+
+		if len(b) != strLen {
+			return fmt.Errorf("expected len %d, got %d", strLen, len(b))
+		}
+
+		return w.WriteBytes(b)
+	case reflect.Slice:
+		if fieldData.Term != nil && fieldValue.Type().Elem().Kind() == reflect.Uint8 {
+			var b []byte
+			if !padding {
+				b = fieldValue.Bytes()
+			}
+
+			return writeTerminated(m.w, b, fieldData)
+		}
+
+		if fieldData.isSentinelTerminated() {
+			if padding {
+				fieldValue = reflect.MakeSlice(fieldValue.Type(), 0, 0)
+			}
+
+			return m.writeSentinelArrayValueFromField(structValue, fieldValue, fieldData, parentStructValues)
+		}
+
+		if fieldData.Length == nil {
+			return errors.New("need set tag with len for slice")
+		}
+
+		arrLen := int(*fieldData.Length)
+		if arrLen < 0 {
+			return ErrNegativeCount
+		}
+
+		if padding {
+			fieldValue = reflect.MakeSlice(fieldValue.Type(), arrLen, arrLen)
+		}
+
+		if fieldValue.Len() != arrLen {
+			return fmt.Errorf("expected len %d, got %d", arrLen, fieldValue.Len())
+		}
+
+		// If slice of bytes, write bytes from slice.
+		if fieldValue.Type().Elem().Kind() == reflect.Uint8 {
+			return m.w.WriteBytes(fieldValue.Bytes())
+		}
+
+		return m.writeArrayValueFromField(arrLen, structValue, fieldValue, fieldData, parentStructValues)
+
+	case reflect.Array:
+		arrLen := fieldValue.Len()
+
+		if fieldData.Length != nil {
+			arrLen = int(*fieldData.Length)
+			if arrLen < 0 {
+				return ErrNegativeCount
+			}
+
+			if arrLen > fieldValue.Len() {
+				return fmt.Errorf("len %d is greater than array len %d", arrLen, fieldValue.Len())
+			}
+		}
+
+		return m.writeArrayValueFromField(arrLen, structValue, fieldValue, fieldData, parentStructValues)
+
+	case reflect.Map:
+		if fieldData.Length == nil {
+			return errors.New("need set tag with len for map")
+		}
+
+		mapLen := int(*fieldData.Length)
+		if mapLen < 0 {
+			return ErrNegativeCount
+		}
+
+		if !padding && fieldValue.Len() != mapLen {
+			return fmt.Errorf("expected len %d, got %d", mapLen, fieldValue.Len())
+		}
+
+		return m.writeMapValueFromField(mapLen, structValue, fieldValue, fieldData, parentStructValues)
+
+	case reflect.Struct:
+		err = m.marshal(fieldValue, append(parentStructValues, structValue))
+		if err != nil {
+			return fmt.Errorf("marshal struct: %w", err)
+		}
+	default:
+		return errors.New(`type "` + fieldValue.Kind().String() + `" not supported`)
+	}
+
+	return nil
+}
+
+// writeMapValueFromField writes mapLen pairs of the key and the value, sorted by the key,
+// so that the same map is always encoded the same way. Zero pairs are written for padding.
+func (m *marshal) writeMapValueFromField(
+	mapLen int, structValue, fieldValue reflect.Value, fieldData *fieldReadData, parentStructValues []reflect.Value,
+) error {
+	t := fieldValue.Type()
+
+	// Keys of padding are invalid values, its map is empty.
+	keys := make([]reflect.Value, mapLen)
+	if fieldValue.Len() == mapLen {
+		keys = fieldValue.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return compareValues(keys[i], keys[j]) < 0
+		})
+	}
+
+	for i, key := range keys {
+		value := reflect.Zero(t.Elem())
+		if key.IsValid() {
+			value = fieldValue.MapIndex(key)
+		} else {
+			key = reflect.Zero(t.Key())
+		}
+
+		err := m.writeValueFromField(structValue, key, fieldData.KeyFieldData, parentStructValues)
+		if err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+
+		err = m.writeValueFromField(structValue, value, fieldData.ValueFieldData, parentStructValues)
+		if err != nil {
+			return fmt.Errorf("value %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// compareValues compares values of the same type of map keys.
+// Values of kinds without an order, such as pointers and interfaces, are equal.
+func compareValues(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float())
+	case reflect.String:
+		return cmp.Compare(a.String(), b.String())
+	case reflect.Bool:
+		switch {
+		case a.Bool() == b.Bool():
+			return 0
+		case b.Bool():
+			return -1
+		default:
+			return 1
+		}
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if c := compareValues(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if c := compareValues(a.Field(i), b.Field(i)); c != 0 {
+				return c
+			}
+		}
+	}
+
+	return 0
+}
+
+// writePrefix writes the length prefix of the type from the tag.
+func writePrefix(w Writer, prefix string, length uint64) error {
+	var max uint64
+	switch prefix {
+	case prefixUint8:
+		max = math.MaxUint8
+	case prefixUint16:
+		max = math.MaxUint16
+	case prefixUint32:
+		max = math.MaxUint32
+	case prefixVarint, prefixZigzag:
+		max = math.MaxInt64
+	default:
+		max = math.MaxUint64
+	}
+
+	if length > max {
+		return fmt.Errorf("len %d overflows %s", length, prefix)
+	}
+
+	switch prefix {
+	case prefixUint8:
+		return w.WriteUint8(uint8(length))
+	case prefixUint16:
+		return w.WriteUint16(uint16(length))
+	case prefixUint32:
+		return w.WriteUint32(uint32(length))
+	case prefixUvarint:
+		return w.WriteUvarint(length)
+	case prefixVLQ:
+		return w.WriteVLQ(length)
+	case prefixVarint:
+		return w.WriteVarint(int64(length))
+	case prefixZigzag:
+		return w.WriteZigzag(int64(length))
+	default:
+		return w.WriteUint64(length)
+	}
+}
+
+// writeVarintValueFromField writes the variable-length integer with the encoding from the tag.
+func writeVarintValueFromField(w Writer, fieldValue reflect.Value, varint string) error {
+	var u uint64
+	var i int64
+	var negative bool
+
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i = fieldValue.Int()
+		u = uint64(i)
+		negative = i < 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u = fieldValue.Uint()
+		i = int64(u)
+		if u > math.MaxInt64 && (varint == bintag.TypeVarint || varint == bintag.TypeZigzag) {
+			return fmt.Errorf("value %d overflows %s", u, varint)
+		}
+	default:
+		return fmt.Errorf("%s is supported only for integers", varint)
+	}
+
+	switch varint {
+	case bintag.TypeUvarint, bintag.TypeVLQ:
+		if negative {
+			return fmt.Errorf("value %d overflows %s", i, varint)
+		}
+
+		if varint == bintag.TypeVLQ {
+			return w.WriteVLQ(u)
+		}
+		return w.WriteUvarint(u)
+	case bintag.TypeVarint:
+		return w.WriteVarint(i)
+	default:
+		return w.WriteZigzag(i)
+	}
+}
+
+// writeTerminated writes the data followed by the terminator from the tag. If len is also set,
+// the data is padded with the terminator to len bytes.
+func writeTerminated(w Writer, b []byte, fieldData *fieldReadData) error {
+	term, err := fieldData.termByte()
+	if err != nil {
+		return err
+	}
+
+	if fieldData.KeepTerm && len(b) != 0 && b[len(b)-1] == term {
+		b = b[:len(b)-1]
+	}
+
+	if bytes.IndexByte(b, term) != -1 {
+		return fmt.Errorf("value contains terminator 0x%02x", term)
+	}
+
+	if fieldData.Length != nil {
+		n := int(*fieldData.Length)
+		if n < 0 {
+			return ErrNegativeCount
+		}
+
+		if len(b) > n {
+			return fmt.Errorf("expected len at most %d, got %d", n, len(b))
+		}
+
+		data := bytes.Repeat([]byte{term}, n)
+		copy(data, b)
+		return w.WriteBytes(data)
+	}
+
+	if fieldData.Max != nil && int64(len(b)+1) > *fieldData.Max {
+		return fmt.Errorf("len %d with terminator is greater than max %d", len(b)+1, *fieldData.Max)
+	}
+
+	data := make([]byte, len(b)+1)
+	copy(data, b)
+	data[len(b)] = term
+	return w.WriteBytes(data)
+}
+
+func (m *marshal) writeArrayValueFromField(
+	arrLen int, structValue, fieldValue reflect.Value, fieldData *fieldReadData, parentStructValues []reflect.Value,
+) error {
+	for i := 0; i < arrLen; i++ {
+		elemData, err := fieldData.elemData(i)
+		if err != nil {
+			return err
+		}
+
+		err = m.writeValueFromField(structValue, fieldValue.Index(i), elemData, parentStructValues)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeSentinelArrayValueFromField writes the elements followed by the sentinel element.
+// If the sentinel is kept in the value, it is written only if it is not the last element.
+// The len tag is the max number of elements, the sentinel is not written if it is reached.
+func (m *marshal) writeSentinelArrayValueFromField(
+	structValue, fieldValue reflect.Value, fieldData *fieldReadData, parentStructValues []reflect.Value,
+) error {
+	elemType := fieldValue.Type().Elem()
+
+	sentinel, err := newSentinel(elemType, fieldData, structValue, parentStructValues)
+	if err != nil {
+		return err
+	}
+
+	n := fieldValue.Len()
+	needSentinel := true
+	for i := 0; i < n; i++ {
+		match, err := sentinel.match(fieldValue.Index(i))
+		if err != nil {
+			return fmt.Errorf("element %d: %w", i, err)
+		}
+
+		if match && !(fieldData.KeepTerm && i == n-1) {
+			return fmt.Errorf("element %d matches the sentinel", i)
+		}
+
+		// The sentinel is kept as the last element.
+		if match {
+			needSentinel = false
+		}
+	}
+
+	if fieldData.Length != nil {
+		max := int(*fieldData.Length)
+		if max < 0 {
+			return ErrNegativeCount
+		}
+
+		if n > max {
+			return fmt.Errorf("len %d is greater than max len %d", n, max)
+		}
+
+		if n == max {
+			needSentinel = false
+		}
+	}
+
+	err = m.writeArrayValueFromField(n, structValue, fieldValue, fieldData, parentStructValues)
+	if err != nil {
+		return err
+	}
+
+	if !needSentinel {
+		return nil
+	}
+
+	v, err := sentinel.value(elemType)
+	if err != nil {
+		return err
+	}
+
+	elemData, err := fieldData.elemData(n)
+	if err != nil {
+		return err
+	}
+
+	return m.writeValueFromField(structValue, v, elemData, parentStructValues)
+}
+
+// writeSizedValueFromField writes the field and pads it with zeros to the size tag bytes.
+func (m *marshal) writeSizedValueFromField(
+	structValue, fieldValue reflect.Value, fieldData *fieldReadData, parentStructValues []reflect.Value,
+) error {
+	size := *fieldData.Size
+	if size < 0 {
+		return ErrNegativeCount
+	}
+
+	start, err := m.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("get current offset: %w", err)
+	}
+
+	err = m.writeValueFromField(structValue, fieldValue, fieldData.sizedData(fieldValue.Type()), parentStructValues)
+	if err != nil {
+		return err
+	}
+
+	end, err := m.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("get current offset: %w", err)
+	}
+
+	n := end - start
+	if n > size {
+		return fmt.Errorf("written %d bytes, greater than size %d", n, size)
+	}
+
+	return m.w.WriteBytes(make([]byte, size-n))
+}
+
+// asMarshaler returns the Marshaler implemented by v or by a pointer to v.
+func asMarshaler(v reflect.Value) (Marshaler, bool) {
+	if !v.CanInterface() {
+		return nil, false
+	}
+
+	if v.CanAddr() {
+		if mr, ok := v.Addr().Interface().(Marshaler); ok {
+			return mr, true
+		}
+	}
+
+	mr, ok := v.Interface().(Marshaler)
+	return mr, ok
+}
+
+// callWriteFunc calls the write method of the struct. Arguments of the tag are passed
+// to the integer parameters after the writer and the optional value.
+func callWriteFunc(w Writer, funcName string, args []int64, structValue, fieldValue reflect.Value) (bool, error) {
+	// Methods of unexported struct fields can't be called
+	if !structValue.CanAddr() || !structValue.CanInterface() {
+		return false, nil
+	}
+
+	// Call methods
+	method, ok := methodByName(structValue.Type(), funcName)
+	if !ok {
+		return false, nil
+	}
+
+	mt := method.Type // the receiver is the first argument
+	if mt.NumOut() != 1 || mt.Out(0) != errorType || mt.NumIn() < 2 || mt.In(1) != writerType {
+		return false, nil
+	}
+
+	in := []reflect.Value{reflect.ValueOf(w)}
+	switch {
+	// Method(w binstruct.Writer, args...) error
+	case mt.NumIn() == 2+len(args):
+
+	// Method(w binstruct.Writer, v FieldType, args...) error
+	case mt.NumIn() == 3+len(args) && mt.In(2) == fieldValue.Type():
+		in = append(in, fieldValue)
+
+	case len(args) == 0:
+		return false, nil
+
+	default:
+		return true, fmt.Errorf("%s: %d arguments in the tag, but the method has %d parameters after the writer",
+			funcName, len(args), mt.NumIn()-2)
+	}
+
+	values, err := methodArgs(mt, len(in)+1, args)
+	if err != nil {
+		return true, fmt.Errorf("%s: %w", funcName, err)
+	}
+
+	ret := structValue.Addr().Method(method.Index).Call(append(in, values...))
+	if !ret[0].IsNil() {
+		return true, ret[0].Interface().(error)
+	}
+
+	return true, nil
+}
+
+// fillLengthFields sets the fields referenced by the len tag of strings, slices and maps
+// to the actual length of these values. Fields of parent structs are already
+// written, so they are only checked. Fields, which are not written because
+// of the if tag, are skipped.
+func fillLengthFields(structValue reflect.Value, parentStructValues []reflect.Value, plan *structPlan) error {
+	filled := make(map[string]int64)
+	env := &exprEnv{Struct: structValue, Parents: parentStructValues}
+
+	for _, field := range plan.Fields {
+		if field.FillLength == nil {
+			continue
+		}
+
+		if field.If != nil {
+			ok, err := field.If.eval(env)
+			if err != nil {
+				return fmt.Errorf(`failed fill len for field "%s": %w`, field.Name, err)
+			}
+
+			if ok == 0 {
+				continue
+			}
+		}
+
+		actual := int64(structValue.Field(field.Index).Len())
+		operand, length, err := field.FillLength.invert(env, actual)
+		if err == nil && operand != nil && !operand.isLocal() {
+			err = fmt.Errorf(`len field "%s" of the parent struct must be %d`, operand.Name, length)
+		}
+		if err != nil {
+			// The value cannot be solved, but it's fine if the length is already correct.
+			if current, e := field.FillLength.eval(env); e == nil && current == actual {
+				continue
+			}
+
+			return fmt.Errorf(`failed fill len for field "%s": %w`, field.Name, err)
+		}
+
+		if operand == nil {
+			continue
+		}
+
+		if prev, ok := filled[operand.Name]; ok && prev != length {
+			return fmt.Errorf(`failed fill len for field "%s": len field "%s" is inconsistent: %d and %d`,
+				field.Name, operand.Name, prev, length)
+		}
+		filled[operand.Name] = length
+
+		err = setFieldValue(structValue, operand, length)
+		if err != nil {
+			return fmt.Errorf(`failed fill len for field "%s": %w`, field.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// setFieldValue sets the integer field referenced by operand to value.
+func setFieldValue(structValue reflect.Value, operand *exprField, value int64) error {
+	if operand.Index == nil {
+		return errors.New("can't set field \"" + operand.Name + "\"")
+	}
+
+	// The value the pointer points to is not a part of the copy of the struct.
+	t := structValue.Type()
+	for _, i := range operand.Index {
+		if t.Kind() == reflect.Ptr {
+			return errors.New("can't set field \"" + operand.Name + "\" through the pointer")
+		}
+		t = t.Field(i).Type
+	}
+
+	sv := structValue.FieldByIndex(operand.Index)
+	if !sv.CanSet() {
+		return errors.New("can't set field \"" + operand.Name + "\"")
+	}
+
+	switch sv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if sv.OverflowInt(value) {
+			return fmt.Errorf(`value %d overflows field "%s"`, value, operand.Name)
+		}
+		sv.SetInt(value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if value < 0 || sv.OverflowUint(uint64(value)) {
+			return fmt.Errorf(`value %d overflows field "%s"`, value, operand.Name)
+		}
+		sv.SetUint(uint64(value))
+	default:
+		return errors.New("can't set field len to \"" + operand.Name + "\" field")
+	}
+
+	return nil
+}
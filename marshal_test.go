@@ -0,0 +1,470 @@
+package binstruct
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MarshalInts(t *testing.T) {
+	type dataStruct struct {
+		I8  int8
+		I16 int16
+		I32 int32
+		I64 int64
+		U16 uint16 `bin:"le"`
+		I3  int32  `bin:"len:3"`
+		U   uint   `bin:"len:2"`
+	}
+
+	v := dataStruct{I8: 1, I16: 2, I32: 3, I64: 4, U16: 5, I3: -1048573, U: 6}
+
+	data, err := MarshalBE(v)
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0x01,
+		0x00, 0x02,
+		0x00, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04,
+		0x05, 0x00,
+		0xf0, 0x00, 0x03,
+		0x00, 0x06,
+	}, data)
+
+	var actual dataStruct
+	err = UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, v, actual)
+}
+
+func Test_MarshalIntsOverflow(t *testing.T) {
+	_, err := MarshalBE(struct {
+		U uint32 `bin:"len:3"`
+	}{U: 0x01000000})
+	require.EqualError(t, err, `failed write value from field "U": value 16777216 overflows 3 bytes`)
+
+	_, err = MarshalBE(struct {
+		I int `bin:"len:2"`
+	}{I: -32769})
+	require.EqualError(t, err, `failed write value from field "I": value -32769 overflows 2 bytes`)
+}
+
+func Test_MarshalRoundTrip(t *testing.T) {
+	type inner struct {
+		Len int8
+		F32 float32
+		F64 float64
+	}
+
+	type dataStruct struct {
+		Inner  inner
+		Bool   bool
+		_      [2]byte
+		Str    string    `bin:"len:Inner.Len"`
+		Bytes  []byte    `bin:"len:3"`
+		Arr    [2]int16  `bin:"le"`
+		Slice  [][]int16 `bin:"len:2,[len:2]"`
+		Skip   []byte    `bin:"-"`
+		Offset uint8     `bin:"offset:1"`
+	}
+
+	v := dataStruct{
+		Inner:  inner{Len: 5, F32: 3.1415927, F64: 3.141592653589793},
+		Bool:   true,
+		Str:    "hello",
+		Bytes:  []byte{0x0a, 0x0b, 0x0c},
+		Arr:    [2]int16{1, 2},
+		Slice:  [][]int16{{3, 4}, {5, 6}},
+		Offset: 0xff,
+	}
+
+	data, err := MarshalLE(&v)
+	require.NoError(t, err)
+
+	var actual dataStruct
+	err = UnmarshalLE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, v, actual)
+}
+
+func Test_MarshalOffsets(t *testing.T) {
+	type dataStruct struct {
+		First byte
+		Last  byte `bin:"offsetStart:4, offsetRestore"`
+		Next  byte
+	}
+
+	data, err := MarshalBE(dataStruct{First: 0x01, Last: 0x05, Next: 0x02})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01, 0x02, 0x00, 0x00, 0x05}, data)
+}
+
+func Test_MarshalLenMismatch(t *testing.T) {
+	type dataStruct struct {
+		Arr []int16 `bin:"len:4"`
+	}
+
+	_, err := MarshalBE(dataStruct{Arr: []int16{1, 2}})
+	require.EqualError(t, err, `failed write value from field "Arr": expected len 4, got 2`)
+}
+
+func Test_MarshalWithoutLenTag(t *testing.T) {
+	type dataStruct struct {
+		Str string
+	}
+
+	_, err := MarshalBE(dataStruct{Str: "hello"})
+	require.EqualError(t, err, `failed write value from field "Str": need set tag with len for string`)
+}
+
+func Test_MarshalInvalid(t *testing.T) {
+	var nilPtr *struct{}
+
+	_, err := MarshalBE(nil)
+	require.EqualError(t, err, "binstruct: Marshal(nil)")
+
+	_, err = MarshalBE(nilPtr)
+	require.EqualError(t, err, "binstruct: Marshal(nil *struct {})")
+
+	_, err = MarshalBE(42)
+	require.EqualError(t, err, "binstruct: Marshal(non-struct int)")
+}
+
+func Test_MarshalFillLength(t *testing.T) {
+	type inner struct {
+		DataLength uint8
+	}
+
+	type dataStruct struct {
+		StrLen  int16
+		Count   uint8
+		Inner   inner
+		Str     string  `bin:"len:StrLen"`
+		Arr     []int16 `bin:"len:Count*2"`
+		Data    []byte  `bin:"len:Inner.DataLength+2"`
+		Reverse []byte  `bin:"len:10-Count"`
+	}
+
+	v := dataStruct{
+		Str:     "hello",
+		Arr:     []int16{1, 2, 3, 4},
+		Data:    []byte{0x0a, 0x0b, 0x0c},
+		Reverse: make([]byte, 8),
+	}
+
+	data, err := MarshalBE(&v)
+	require.NoError(t, err)
+	require.Equal(t, dataStruct{
+		Str:     "hello",
+		Arr:     []int16{1, 2, 3, 4},
+		Data:    []byte{0x0a, 0x0b, 0x0c},
+		Reverse: make([]byte, 8),
+	}, v, "the value passed to Marshal must not be modified")
+
+	var actual dataStruct
+	err = UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, dataStruct{
+		StrLen:  5,
+		Count:   2,
+		Inner:   inner{DataLength: 1},
+		Str:     "hello",
+		Arr:     []int16{1, 2, 3, 4},
+		Data:    []byte{0x0a, 0x0b, 0x0c},
+		Reverse: make([]byte, 8),
+	}, actual)
+}
+
+func Test_MarshalFillLengthFuncs(t *testing.T) {
+	type header struct {
+		Version uint16
+	}
+
+	type dataStruct struct {
+		Header header
+		Count  uint8
+		Left   []byte `bin:"len:sizeof(Header)+Count"`
+		Right  []byte `bin:"len:Count+sizeof(Header)"`
+	}
+
+	data, err := MarshalBE(dataStruct{Left: []byte{1, 2, 3}, Right: []byte{4, 5, 6}})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0x00, 0x01, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06}, data)
+}
+
+func Test_MarshalFillLengthErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       interface{}
+		wantErr string
+	}{
+		{
+			name: "inconsistent",
+			v: struct {
+				Len uint8
+				A   []byte `bin:"len:Len"`
+				B   []byte `bin:"len:Len"`
+			}{A: []byte{1}, B: []byte{1, 2}},
+			wantErr: `failed fill len for field "B": len field "Len" is inconsistent: 1 and 2`,
+		},
+		{
+			name: "not a multiple",
+			v: struct {
+				Len uint8
+				A   []byte `bin:"len:Len*2"`
+			}{A: []byte{1, 2, 3}},
+			wantErr: `failed fill len for field "A": cannot invert "Len*2": 3 is not a multiple of 2`,
+		},
+		{
+			name: "division",
+			v: struct {
+				Len uint8
+				A   []byte `bin:"len:Len/3"`
+			}{Len: 3, A: []byte{1, 2}},
+			wantErr: `failed fill len for field "A": cannot invert "Len/3": division is not invertible`,
+		},
+		{
+			name: "overflow",
+			v: struct {
+				Len uint8
+				A   []byte `bin:"len:Len"`
+			}{A: make([]byte, 256)},
+			wantErr: `failed fill len for field "A": value 256 overflows field "Len"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := MarshalBE(tt.v)
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func Test_MarshalFillLengthNotInvertibleButCorrect(t *testing.T) {
+	type dataStruct struct {
+		Len uint8
+		A   []byte `bin:"len:Len/3"`
+	}
+
+	data, err := MarshalBE(dataStruct{Len: 6, A: []byte{1, 2}})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x06, 0x01, 0x02}, data)
+}
+
+func Test_MarshalFillLengthTerminatedString(t *testing.T) {
+	type dataStruct struct {
+		Width uint8
+		Name  string `bin:"len:Width,cstring"`
+	}
+
+	v := dataStruct{Width: 8, Name: "ab"}
+
+	data, err := MarshalBE(v)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x08, 'a', 'b', 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, data)
+
+	var actual dataStruct
+	err = UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, v, actual)
+}
+
+func Test_MarshalFillLengthIf(t *testing.T) {
+	type dataStruct struct {
+		Flags uint8
+		Count uint8
+		Data  []byte `bin:"if:Flags!=0,len:Count"`
+	}
+
+	data, err := MarshalBE(dataStruct{Flags: 1, Data: []byte{1, 2}})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01, 0x02, 0x01, 0x02}, data)
+
+	// The field is not written, so its len field is not filled.
+	data, err = MarshalBE(dataStruct{Flags: 0, Data: []byte{1, 2}})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0x00}, data)
+
+	var actual dataStruct
+	err = UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, dataStruct{}, actual)
+}
+
+func Test_MarshalCString(t *testing.T) {
+	type dataStruct struct {
+		Str   string `bin:"cstring"`
+		Line  string `bin:"term:0x0a"`
+		Fixed string `bin:"len:4,cstring"`
+		Raw   []byte `bin:"cstring,max:4"`
+	}
+
+	v := dataStruct{Str: "hi", Line: "line", Fixed: "ab", Raw: []byte("raw")}
+
+	data, err := MarshalBE(v)
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		'h', 'i', 0x00,
+		'l', 'i', 'n', 'e', '\n',
+		'a', 'b', 0x00, 0x00,
+		'r', 'a', 'w', 0x00,
+	}, data)
+
+	var actual dataStruct
+	err = UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, v, actual)
+
+	_, err = MarshalBE(dataStruct{Str: "a\x00b"})
+	require.EqualError(t, err, `failed write value from field "Str": value contains terminator 0x00`)
+
+	_, err = MarshalBE(dataStruct{Fixed: "hello"})
+	require.EqualError(t, err, `failed write value from field "Fixed": expected len at most 4, got 5`)
+
+	_, err = MarshalBE(dataStruct{Raw: []byte("long")})
+	require.EqualError(t, err, `failed write value from field "Raw": len 5 with terminator is greater than max 4`)
+}
+
+type dataCustomWriteMethodStruct struct {
+	Type   string   `bin:"ReadNullTerminated,write:WriteNullTerminated"`
+	Values []uint16 `bin:"len:2,[ReadValue,write:WriteValue]"`
+	Custom uint8    `bin:"ReadCustom,write:WriteCustom"`
+}
+
+func (*dataCustomWriteMethodStruct) ReadNullTerminated(r Reader) (string, error) {
+	var b []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		if c == 0x00 {
+			return string(b), nil
+		}
+
+		b = append(b, c)
+	}
+}
+
+func (*dataCustomWriteMethodStruct) WriteNullTerminated(w Writer, v string) error {
+	return w.WriteBytes(append([]byte(v), 0x00))
+}
+
+func (*dataCustomWriteMethodStruct) ReadValue(r Reader) (uint16, error) {
+	v, err := r.ReadUint8()
+	return uint16(v), err
+}
+
+func (*dataCustomWriteMethodStruct) WriteValue(w Writer, v uint16) error {
+	return w.WriteUint8(uint8(v))
+}
+
+func (d *dataCustomWriteMethodStruct) ReadCustom(r Reader) error {
+	v, err := r.ReadUint8()
+	d.Custom = v - 1
+	return err
+}
+
+func (d *dataCustomWriteMethodStruct) WriteCustom(w Writer) error {
+	return w.WriteUint8(d.Custom + 1)
+}
+
+func Test_MarshalCustomWriteMethod(t *testing.T) {
+	v := dataCustomWriteMethodStruct{
+		Type:   "test",
+		Values: []uint16{1, 2},
+		Custom: 5,
+	}
+
+	data, err := MarshalBE(v)
+	require.NoError(t, err)
+	require.Equal(t, []byte{'t', 'e', 's', 't', 0x00, 0x01, 0x02, 0x06}, data)
+
+	var actual dataCustomWriteMethodStruct
+	err = UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, v, actual)
+}
+
+type CustomWriteMethodFromParent struct {
+	Pin struct {
+		Checksum uint16 `bin:"CustomMethodFromParent,write:CustomWriteMethodFromParent"`
+	}
+}
+
+func (*CustomWriteMethodFromParent) CustomMethodFromParent(r Reader) (uint16, error) {
+	return r.WithOrder(binary.LittleEndian).ReadUint16()
+}
+
+func (*CustomWriteMethodFromParent) CustomWriteMethodFromParent(w Writer, v uint16) error {
+	return w.WithOrder(binary.LittleEndian).WriteUint16(v)
+}
+
+func Test_MarshalCustomWriteMethodFromParent(t *testing.T) {
+	var v CustomWriteMethodFromParent
+	v.Pin.Checksum = 1
+
+	data, err := MarshalBE(v)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01, 0x00}, data)
+
+	var actual CustomWriteMethodFromParent
+	err = UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, v, actual)
+}
+
+func Test_MarshalCustomWriteMethodNotExist(t *testing.T) {
+	type dataCustomWriteMethodStruct struct {
+		Custom string `bin:"CustomMethod,write:CustomWriteMethodNotExist"`
+	}
+
+	_, err := MarshalBE(dataCustomWriteMethodStruct{})
+	require.EqualError(t, err, `failed write value from field "Custom": 
+failed call method, expected methods:
+	func (*dataCustomWriteMethodStruct) CustomWriteMethodNotExist(w binstruct.Writer) error {} 
+or
+	func (*dataCustomWriteMethodStruct) CustomWriteMethodNotExist(w binstruct.Writer, v string) error {}
+`)
+}
+
+func Test_MarshalCustomReadMethodWithoutWrite(t *testing.T) {
+	type dataCustomWriteMethodStruct struct {
+		Custom string `bin:"CustomMethod"`
+	}
+
+	_, err := MarshalBE(dataCustomWriteMethodStruct{})
+	require.EqualError(t, err, `failed write value from field "Custom": need set tag with write method for field with read method CustomMethod, e.g. "CustomMethod,write:MethodName"`)
+}
+
+func Test_Marshaler(t *testing.T) {
+	v := dataUnmarshalerStruct{
+		Date:    dosDate{Year: 2021, Month: 1, Day: 1},
+		DateLE:  dosDate{Year: 2021, Month: 1, Day: 1},
+		Names:   []pascalString{"hi", "yay"},
+		Trailer: 0xff,
+	}
+
+	data, err := MarshalBE(v)
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0x52, 0x21,
+		0x21, 0x52,
+		0x02, 'h', 'i',
+		0x03, 'y', 'a', 'y',
+		0x00,
+		0xff,
+	}, data)
+
+	data, err = MarshalBE(v.Date)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x52, 0x21}, data)
+
+	name := pascalString("hi")
+	data, err = MarshalBE(name)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x02, 'h', 'i'}, data)
+}
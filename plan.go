@@ -0,0 +1,213 @@
+package binstruct
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/ghostiam/binstruct/internal/bintag"
+)
+
+// structPlan is the compiled description of a struct type: tags of all fields
+// are parsed and the referenced fields are resolved once per type.
+type structPlan struct {
+	Fields []structFieldPlan
+
+	// FillLength is true if the len tag of some string or slice refers
+	// to a field, which is filled from the actual length on write.
+	FillLength bool
+	// CopyOnWrite is true if the struct or any nested struct fills length
+	// fields, so a copy must be made to not modify the value passed to Marshal.
+	CopyOnWrite bool
+	// BitOrder is the default order of bits of the bit fields, set by the blank
+	// struct{} field with the lsb or msb tag. It is msb if empty.
+	BitOrder string
+}
+
+type structFieldPlan struct {
+	*fieldPlan
+
+	Index int
+	Name  string
+
+	// FillLength is the len tag value to fill the referenced field on write.
+	FillLength *tagValue
+}
+
+var structPlans sync.Map // map[reflect.Type]*structPlan
+
+func getStructPlan(structType reflect.Type) (*structPlan, error) {
+	if p, ok := structPlans.Load(structType); ok {
+		return p.(*structPlan), nil
+	}
+
+	p, err := compileStructPlan(structType)
+	if err != nil {
+		return nil, err
+	}
+
+	actual, _ := structPlans.LoadOrStore(structType, p)
+	return actual.(*structPlan), nil
+}
+
+func compileStructPlan(structType reflect.Type) (*structPlan, error) {
+	if structType.Kind() != reflect.Struct {
+		return nil, errors.New(`type "` + structType.Kind().String() + `" not supported`)
+	}
+
+	numField := structType.NumField()
+	p := &structPlan{
+		Fields: make([]structFieldPlan, numField),
+	}
+
+	for i := 0; i < numField; i++ {
+		fieldType := structType.Field(i)
+		tags, err := bintag.Parse(fieldType.Tag.Get(bintag.Name))
+		if err != nil {
+			return nil, fmt.Errorf(`failed parseTag for field "%s": %w`, fieldType.Name, err)
+		}
+
+		fieldPlan, err := compileFieldPlan(structType, fieldType.Type, tags)
+		if err != nil {
+			return nil, fmt.Errorf(`failed parseTag for field "%s": %w`, fieldType.Name, err)
+		}
+
+		if fieldPlan.BitOrder != "" && fieldPlan.Bits == nil {
+			if !isBitOrderField(fieldType, tags) {
+				return nil, fmt.Errorf(`failed parseTag for field "%s": lsb and msb are supported only for bit fields, `+
+					`use the blank struct{} field to set the bit order of the struct`, fieldType.Name)
+			}
+
+			if p.BitOrder != "" && p.BitOrder != fieldPlan.BitOrder {
+				return nil, fmt.Errorf(`failed parseTag for field "%s": bit order of the struct is already set to %s`,
+					fieldType.Name, p.BitOrder)
+			}
+
+			// The field only sets the order, it is not read or written.
+			p.BitOrder = fieldPlan.BitOrder
+			fieldPlan = bitOrderFieldPlan
+		}
+
+		fp := structFieldPlan{
+			fieldPlan: fieldPlan,
+			Index:     i,
+			Name:      fieldType.Name,
+		}
+
+		exported := fieldType.PkgPath == "" || fieldType.Anonymous
+		custom := fp.FuncName != "" || fp.WriteFuncName != ""
+		// The len tag of terminated values is the width or the max number of elements, not the length.
+		terminated := fp.Term != nil || fp.Until != nil || fp.KeepTerm
+
+		if terminated && !custom && !fp.Ignore && fieldType.Type.Kind() == reflect.Array {
+			return nil, fmt.Errorf(`failed parseTag for field "%s": term, until and keepTerm are not supported for arrays, use a slice`,
+				fieldType.Name)
+		}
+
+		switch fieldType.Type.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map:
+			// A len tag with size() or remaining() is the actual length on write, as rest.
+			if exported && !custom && !terminated && !fp.Ignore && fp.Length != nil && !fp.Length.isConst() &&
+				!fp.lengthToEnd {
+				fp.FillLength = fp.Length
+				p.FillLength = true
+			}
+		}
+
+		if !fp.Ignore && !custom && !p.CopyOnWrite {
+			t := fieldType.Type
+			for t.Kind() == reflect.Array {
+				t = t.Elem()
+			}
+
+			if t.Kind() == reflect.Struct {
+				nested, err := getStructPlan(t)
+				if err != nil {
+					return nil, fmt.Errorf(`failed compile struct for field "%s": %w`, fieldType.Name, err)
+				}
+
+				p.CopyOnWrite = nested.CopyOnWrite
+			}
+		}
+
+		p.Fields[i] = fp
+	}
+
+	p.CopyOnWrite = p.CopyOnWrite || p.FillLength
+
+	return p, nil
+}
+
+// bitOrderFieldPlan is the plan of the field, which sets the bit order of the struct.
+var bitOrderFieldPlan = &fieldPlan{
+	fieldTags: fieldTags{Ignore: true},
+	static:    &fieldReadData{fieldTags: &fieldTags{Ignore: true}},
+}
+
+// isBitOrderField reports whether the field is the blank struct{} field with only the lsb or msb tag,
+// which sets the default bit order of the struct.
+func isBitOrderField(field reflect.StructField, tags []bintag.Tag) bool {
+	return field.Name == "_" && field.Type.Kind() == reflect.Struct && field.Type.NumField() == 0 && len(tags) == 1
+}
+
+type methodKey struct {
+	Type reflect.Type
+	Name string
+}
+
+var methods sync.Map // map[methodKey]*reflect.Method
+
+// methodByName returns the method of the pointer to the struct type,
+// the lookup is done once per type and name.
+func methodByName(structType reflect.Type, name string) (reflect.Method, bool) {
+	key := methodKey{Type: structType, Name: name}
+	if m, ok := methods.Load(key); ok {
+		if m == nil {
+			return reflect.Method{}, false
+		}
+		return *m.(*reflect.Method), true
+	}
+
+	m, ok := reflect.PointerTo(structType).MethodByName(name)
+	if !ok {
+		methods.Store(key, nil)
+		return reflect.Method{}, false
+	}
+
+	methods.Store(key, &m)
+	return m, true
+}
+
+type fieldKey struct {
+	Type reflect.Type
+	Name string
+}
+
+var fieldIndexes sync.Map // map[fieldKey][]int
+
+// fieldIndex is the same as fieldIndexByName, but the lookup is done once per type and name.
+// It is used for fields of parent structs, which are not known when the plan is compiled.
+func fieldIndex(structType reflect.Type, name string) []int {
+	key := fieldKey{Type: structType, Name: name}
+	if index, ok := fieldIndexes.Load(key); ok {
+		return index.([]int)
+	}
+
+	index := fieldIndexByName(structType, name)
+	fieldIndexes.Store(key, index)
+	return index
+}
+
+var unmarshalers sync.Map // map[reflect.Type]bool
+
+// isUnmarshaler reports whether the pointer to the type implements Unmarshaler.
+func isUnmarshaler(t reflect.Type) bool {
+	if ok, found := unmarshalers.Load(t); found {
+		return ok.(bool)
+	}
+
+	ok := reflect.PointerTo(t).Implements(unmarshalerType)
+	unmarshalers.Store(t, ok)
+	return ok
+}
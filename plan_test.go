@@ -0,0 +1,90 @@
+package binstruct
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getStructPlanCached(t *testing.T) {
+	type dataStruct struct {
+		Len  uint8
+		Data []byte `bin:"len:Len"`
+		Skip []byte `bin:"-"`
+	}
+
+	p1, err := getStructPlan(reflect.TypeOf(dataStruct{}))
+	require.NoError(t, err)
+
+	p2, err := getStructPlan(reflect.TypeOf(dataStruct{}))
+	require.NoError(t, err)
+	require.True(t, p1 == p2, "plan must be compiled once per type")
+
+	require.Len(t, p1.Fields, 3)
+	require.Nil(t, p1.Fields[1].static, "len refers to the field, so it must be calculated on read")
+	require.Equal(t, []int{0}, p1.Fields[1].Length.Root.(*exprField).Index)
+	require.NotNil(t, p1.Fields[2].static)
+	require.True(t, p1.FillLength)
+	require.True(t, p1.CopyOnWrite)
+}
+
+func Test_getStructPlanError(t *testing.T) {
+	type dataStruct struct {
+		Data []byte `bin:"[len:1"`
+	}
+
+	_, err := getStructPlan(reflect.TypeOf(dataStruct{}))
+	require.EqualError(t, err, `failed parseTag for field "Data": unbalanced square bracket`)
+
+	var actual dataStruct
+	err = UnmarshalBE([]byte{0x01}, &actual)
+	require.EqualError(t, err, `failed parseTag for field "Data": unbalanced square bracket`)
+}
+
+func Test_StructPlanConcurrent(t *testing.T) {
+	type item struct {
+		Len  uint8
+		Name string `bin:"len:Len"`
+	}
+
+	type dataStruct struct {
+		Count uint8
+		Items []item `bin:"len:Count"`
+	}
+
+	want := dataStruct{
+		Count: 2,
+		Items: []item{{Len: 2, Name: "hi"}, {Len: 3, Name: "yay"}},
+	}
+
+	data, err := MarshalBE(want)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var actual dataStruct
+			if err := UnmarshalBE(data, &actual); err != nil {
+				errs <- err
+				return
+			}
+
+			if !reflect.DeepEqual(want, actual) {
+				errs <- errors.New("decoded value mismatch")
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
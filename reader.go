@@ -13,6 +13,10 @@ import (
 var (
 	// ErrNegativeCount is returned when an attempt is made to read a negative number of bytes
 	ErrNegativeCount = errors.New("binstruct: negative count")
+	// ErrTerminatorNotFound is returned when the terminator is not found within the max number of bytes
+	ErrTerminatorNotFound = errors.New("binstruct: terminator not found")
+	// ErrVarintOverflow is returned when the variable-length integer doesn't fit into 64 bits
+	ErrVarintOverflow = errors.New("binstruct: varint overflows a 64-bit integer")
 )
 
 // Reader is the interface that wraps the binstruct reader methods.
@@ -27,6 +31,11 @@ type Reader interface {
 	ReadBytes(n int) (an int, b []byte, err error)
 	// ReadAll reads until an error or EOF and returns the data it read.
 	ReadAll() ([]byte, error)
+	// ReadUntil reads until the first occurrence of delim and returns the data
+	// before the delimiter. The delimiter is consumed, but not returned.
+	ReadUntil(delim byte) ([]byte, error)
+	// ReadCString reads a null-terminated string
+	ReadCString() (string, error)
 
 	// ReadByte read and return one byte
 	ReadByte() (byte, error)
@@ -60,6 +69,30 @@ type Reader interface {
 	// ReadFloat64 read eight bytes and return float64 value
 	ReadFloat64() (float64, error)
 
+	// ReadUvarint reads the unsigned LEB128 integer
+	ReadUvarint() (uint64, error)
+	// ReadVarint reads the signed LEB128 integer
+	ReadVarint() (int64, error)
+	// ReadZigzag reads the zigzag-encoded LEB128 integer, as encoding/binary varint
+	ReadZigzag() (int64, error)
+	// ReadVLQ reads the big-endian variable-length quantity, as MIDI
+	ReadVLQ() (uint64, error)
+
+	// ReadBits reads n bits, up to 64, starting from the most significant bit of the byte.
+	// The unread bits of the current byte are kept for the next bit read, reading bytes
+	// or seeking to another position discards them.
+	ReadBits(n int) (uint64, error)
+	// ReadBit reads one bit and returns boolean value
+	ReadBit() (bool, error)
+	// ReadUE reads the unsigned Exp-Golomb code
+	ReadUE() (uint64, error)
+	// ReadSE reads the signed Exp-Golomb code
+	ReadSE() (int64, error)
+	// AlignToByte discards the unread bits of the current byte
+	AlignToByte()
+	// BitPosition returns the current offset in bits
+	BitPosition() (int64, error)
+
 	// Unmarshal parses the binary data and stores the result
 	// in the value pointed to by v.
 	Unmarshal(v interface{}) error
@@ -74,6 +107,7 @@ func NewReader(r io.ReadSeeker, order binary.ByteOrder, debug bool) Reader {
 	return &reader{
 		r:     r,
 		order: order,
+		bits:  &bitReader{},
 		debug: debug,
 	}
 }
@@ -87,6 +121,9 @@ func NewReaderFromBytes(data []byte, order binary.ByteOrder, debug bool) Reader
 type reader struct {
 	r     io.ReadSeeker
 	order binary.ByteOrder
+	bits  *bitReader // shared with the readers returned by WithOrder
+
+	registry *Registry // decode functions of the Decoder, if set
 
 	debug bool
 }
@@ -101,6 +138,73 @@ func (r *reader) ReadAll() ([]byte, error) {
 	return b, err
 }
 
+// If an EOF happens before the delimiter, ReadUntil returns the data it read and
+// io.ErrUnexpectedEOF, or io.EOF if no bytes were read.
+func (r *reader) ReadUntil(delim byte) ([]byte, error) {
+	b, err := readUntil(r, delim, -1)
+
+	if r.debug {
+		fmt.Printf("ReadUntil(0x%02x): %s", delim, hex.Dump(b))
+	}
+
+	return b, err
+}
+
+func (r *reader) ReadCString() (string, error) {
+	b, err := r.ReadUntil(0x00)
+	return string(b), err
+}
+
+// readUntil reads until the first occurrence of delim, as Reader.ReadUntil does.
+// If max is not negative, at most max bytes are read, including the delimiter,
+// otherwise ErrTerminatorNotFound is returned.
+func readUntil(r io.ReadSeeker, delim byte, max int) ([]byte, error) {
+	data := []byte{}
+	var buf [64]byte
+
+	for {
+		chunk := buf[:]
+		if max >= 0 {
+			left := max - len(data)
+			if left == 0 {
+				return data, fmt.Errorf("%w within %d bytes", ErrTerminatorNotFound, max)
+			}
+
+			if left < len(chunk) {
+				chunk = chunk[:left]
+			}
+		}
+
+		n, err := r.Read(chunk)
+		if i := bytes.IndexByte(chunk[:n], delim); i != -1 {
+			data = append(data, chunk[:i]...)
+
+			// Set offset back to the byte after the delimiter.
+			if back := n - i - 1; back > 0 {
+				_, err = r.Seek(int64(-back), io.SeekCurrent)
+				if err != nil {
+					return data, err
+				}
+			}
+
+			return data, nil
+		}
+
+		data = append(data, chunk[:n]...)
+
+		if err != nil {
+			if err == io.EOF && len(data) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return data, err
+		}
+	}
+}
+
+// readBytesChunk is the max number of bytes allocated before they are read.
+const readBytesChunk = 1 << 16
+
 // If an EOF happens after reading some but not all the bytes, ReadBytes returns io.ErrUnexpectedEOF.
 func (r *reader) ReadBytes(n int) (an int, b []byte, err error) {
 	if n < 0 {
@@ -111,8 +215,23 @@ func (r *reader) ReadBytes(n int) (an int, b []byte, err error) {
 		return 0, []byte{}, nil
 	}
 
-	b = make([]byte, n)
-	an, err = io.ReadFull(r, b)
+	// The count can come from the input, so a large buffer grows in chunks as the bytes are read,
+	// and a short input fails before the whole count is allocated.
+	b = make([]byte, min(n, readBytesChunk))
+	for {
+		var rn int
+		rn, err = io.ReadFull(r, b[an:])
+		an += rn
+		if err != nil || an == n {
+			break
+		}
+
+		b = append(b, make([]byte, min(n-an, len(b)))...)
+	}
+
+	if err == io.EOF && an > 0 {
+		err = io.ErrUnexpectedEOF
+	}
 
 	if r.debug {
 		fmt.Printf("Read(want: %d|actual: %d): %s", n, an, hex.Dump(b))
@@ -253,13 +372,182 @@ func (r *reader) ReadFloat64() (float64, error) {
 	return float, nil
 }
 
+// If an EOF happens after reading some but not all the bytes, ReadUvarint returns io.ErrUnexpectedEOF.
+func (r *reader) ReadUvarint() (uint64, error) {
+	var v uint64
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && i > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+
+		// The tenth byte holds the last bit.
+		if i == 9 && b > 1 {
+			return 0, ErrVarintOverflow
+		}
+
+		v |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			return v, nil
+		}
+	}
+}
+
+func (r *reader) ReadVarint() (int64, error) {
+	var v int64
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && i > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+
+		// The tenth byte holds the last bit, the rest is the sign extension.
+		if i == 9 && b != 0x00 && b != 0x7f {
+			return 0, ErrVarintOverflow
+		}
+
+		v |= int64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			if shift := 7 * (i + 1); shift < 64 && b&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v, nil
+		}
+	}
+}
+
+func (r *reader) ReadZigzag() (int64, error) {
+	u, err := r.ReadUvarint()
+	return int64(u>>1) ^ -int64(u&1), err
+}
+
+func (r *reader) ReadVLQ() (uint64, error) {
+	var v uint64
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && i > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+
+		if i == 10 || v>>57 != 0 {
+			return 0, ErrVarintOverflow
+		}
+
+		v = v<<7 | uint64(b&0x7f)
+		if b < 0x80 {
+			return v, nil
+		}
+	}
+}
+
+// If an EOF happens after reading some but not all the bits, ReadBits returns io.ErrUnexpectedEOF.
+func (r *reader) ReadBits(n int) (uint64, error) {
+	if n < 0 {
+		return 0, ErrNegativeCount
+	}
+
+	if n > 64 {
+		return 0, errors.New("cannot read more than 64 bits")
+	}
+
+	v, err := r.bits.readBits(r.r, n, false)
+
+	if r.debug {
+		fmt.Printf("ReadBits(%d): 0x%x\n", n, v)
+	}
+
+	return v, err
+}
+
+func (r *reader) ReadBit() (bool, error) {
+	v, err := r.ReadBits(1)
+	return v == 1, err
+}
+
+func (r *reader) ReadUE() (uint64, error) {
+	// The code is the number of leading zero bits, the one bit
+	// and the same number of bits of the value.
+	var zeros int
+	for {
+		bit, err := r.ReadBit()
+		if err != nil {
+			if err == io.EOF && zeros > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+
+		if bit {
+			break
+		}
+
+		zeros++
+		if zeros == 64 {
+			return 0, errors.New("exp-golomb code overflows uint64")
+		}
+	}
+
+	v, err := r.ReadBits(zeros)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+
+	return 1<<zeros - 1 + v, nil
+}
+
+func (r *reader) ReadSE() (int64, error) {
+	k, err := r.ReadUE()
+	if err != nil {
+		return 0, err
+	}
+
+	// 0, 1, -1, 2, -2, ...
+	if k&1 == 1 {
+		return int64(k>>1) + 1, nil
+	}
+
+	return -int64(k >> 1), nil
+}
+
+func (r *reader) AlignToByte() {
+	r.bits.align()
+}
+
+func (r *reader) BitPosition() (int64, error) {
+	pos, err := r.r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	// The current byte is already read.
+	return pos*8 - int64(r.bits.n), nil
+}
+
 // io.Reader
 func (r *reader) Read(p []byte) (n int, err error) {
+	r.bits.align()
 	return r.r.Read(p)
 }
 
 // io.Seeker
 func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	// Getting the current offset keeps the unread bits.
+	if offset != 0 || whence != io.SeekCurrent {
+		r.bits.align()
+	}
+
 	i, err := r.r.Seek(offset, whence)
 
 	if r.debug {
@@ -295,5 +583,69 @@ func (r *reader) Unmarshal(v interface{}) error {
 }
 
 func (r *reader) WithOrder(order binary.ByteOrder) Reader {
-	return NewReader(r, order, r.debug)
+	return &reader{
+		r:        r,
+		order:    order,
+		bits:     r.bits,
+		registry: r.registry,
+		debug:    r.debug,
+	}
+}
+
+// sectionReader reads from r until the end of the section. Reading past the end
+// returns io.EOF, seeking is not limited and uses the offsets of r, but io.SeekEnd
+// is relative to the end of the section.
+type sectionReader struct {
+	r   io.ReadSeeker
+	pos int64 // current offset of r
+	end int64 // offset of r at the end of the section
+}
+
+func (s *sectionReader) Read(p []byte) (n int, err error) {
+	if s.pos >= s.end {
+		return 0, io.EOF
+	}
+
+	if left := s.end - s.pos; int64(len(p)) > left {
+		p = p[:left]
+	}
+
+	n, err = s.r.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+func (s *sectionReader) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekEnd {
+		offset, whence = s.end+offset, io.SeekStart
+	}
+
+	pos, err := s.r.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+
+	s.pos = pos
+	return pos, nil
+}
+
+// limitReader returns a new reader that reads at most n bytes from the current offset of r.
+func limitReader(r Reader, n int64) (Reader, error) {
+	rr, ok := r.(*reader)
+	if !ok {
+		return nil, fmt.Errorf("reader %T can't be limited", r)
+	}
+
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("get current offset: %w", err)
+	}
+
+	return &reader{
+		r:        &sectionReader{r: r, pos: pos, end: pos + n},
+		order:    rr.order,
+		bits:     &bitReader{},
+		registry: rr.registry,
+		debug:    rr.debug,
+	}, nil
 }
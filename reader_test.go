@@ -0,0 +1,150 @@
+package binstruct
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReaderReadUntil(t *testing.T) {
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = 'a'
+	}
+	data[70] = 0x00
+	data[71] = '\n'
+
+	r := NewReaderFromBytes(data, binary.BigEndian, false)
+
+	b, err := r.ReadUntil(0x00)
+	require.NoError(t, err)
+	require.Equal(t, data[:70], b)
+
+	b, err = r.ReadUntil('\n')
+	require.NoError(t, err)
+	require.Equal(t, []byte{}, b)
+
+	s, err := r.ReadCString()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+	require.Equal(t, string(data[72:]), s)
+
+	_, err = r.ReadCString()
+	require.Equal(t, io.EOF, err)
+}
+
+func Test_ReaderReadBits(t *testing.T) {
+	r := NewReaderFromBytes([]byte{0b101_10011, 0xab, 0xcd, 0x80, 0x01, 0x02}, binary.BigEndian, false)
+
+	v, err := r.ReadBits(3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0b101), v)
+
+	bit, err := r.ReadBit()
+	require.NoError(t, err)
+	require.True(t, bit)
+
+	pos, err := r.BitPosition()
+	require.NoError(t, err)
+	require.Equal(t, int64(4), pos)
+
+	// The readers with another byte order share the bits.
+	v, err = r.WithOrder(binary.LittleEndian).ReadBits(12)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0x3ab), v)
+
+	// Reading bytes discards the unread bits.
+	b, err := r.ReadByte()
+	require.NoError(t, err)
+	require.Equal(t, byte(0xcd), b)
+
+	bit, err = r.ReadBit()
+	require.NoError(t, err)
+	require.True(t, bit)
+
+	r.AlignToByte()
+
+	pos, err = r.BitPosition()
+	require.NoError(t, err)
+	require.Equal(t, int64(32), pos)
+
+	// Seeking discards the unread bits too.
+	_, err = r.ReadBits(4)
+	require.NoError(t, err)
+
+	_, err = r.Seek(-1, io.SeekCurrent)
+	require.NoError(t, err)
+
+	v, err = r.ReadBits(16)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0x0102), v)
+
+	_, err = r.ReadBits(1)
+	require.Equal(t, io.EOF, err)
+
+	_, err = r.ReadBits(65)
+	require.EqualError(t, err, "cannot read more than 64 bits")
+}
+
+func Test_ReaderReadExpGolomb(t *testing.T) {
+	// 1, 010, 011, 00100, 00101 and padding
+	r := NewReaderFromBytes([]byte{0b1010_0110, 0b0100_0010, 0b1_0000000}, binary.BigEndian, false)
+
+	for _, want := range []uint64{0, 1, 2, 3} {
+		v, err := r.ReadUE()
+		require.NoError(t, err)
+		require.Equal(t, want, v)
+	}
+
+	s, err := r.ReadSE()
+	require.NoError(t, err)
+	require.Equal(t, int64(-2), s)
+
+	_, err = r.ReadUE()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func Test_ReaderReadVarint(t *testing.T) {
+	r := NewReaderFromBytes([]byte{
+		0xe5, 0x8e, 0x26,
+		0xc0, 0xbb, 0x78,
+		0x7f,
+		0x7f,
+		0x81, 0x00,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+		0x80,
+	}, binary.BigEndian, false)
+
+	u, err := r.ReadUvarint()
+	require.NoError(t, err)
+	require.Equal(t, uint64(624485), u)
+
+	i, err := r.ReadVarint()
+	require.NoError(t, err)
+	require.Equal(t, int64(-123456), i)
+
+	i, err = r.ReadVarint()
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), i)
+
+	i, err = r.ReadZigzag()
+	require.NoError(t, err)
+	require.Equal(t, int64(-64), i)
+
+	u, err = r.ReadVLQ()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0x80), u)
+
+	u, err = r.ReadUvarint()
+	require.NoError(t, err)
+	require.Equal(t, uint64(math.MaxUint64), u)
+
+	_, err = r.ReadUvarint()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	r = NewReaderFromBytes([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02}, binary.BigEndian, false)
+	_, err = r.ReadUvarint()
+	require.Equal(t, ErrVarintOverflow, err)
+}
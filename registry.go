@@ -0,0 +1,123 @@
+package binstruct
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds decode functions, which are called by name from the tags,
+// and decoders of types, which are used for any field of the type without a tag.
+// Functions of the registry set to the Decoder take precedence over the global ones.
+type Registry struct {
+	funcs sync.Map // map[string]*decodeFunc
+	types sync.Map // map[reflect.Type]*decodeFunc
+
+	numTypes atomic.Int32 // number of types, to skip the lookup of every field if none
+}
+
+// NewRegistry returns a new empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+var globalRegistry = NewRegistry()
+
+// RegisterFunc registers fn in the global registry, so that the tag `bin:"name"`
+// of any field of type T calls it, if the struct and its parents have no method with this name.
+func RegisterFunc[T any](name string, fn func(r Reader) (T, error)) {
+	RegisterFuncIn(globalRegistry, name, fn)
+}
+
+// RegisterType registers fn in the global registry, so that any field of type T
+// without a method tag is decoded by it.
+//
+// The decoder is used only by Unmarshal and Decoder. Marshal and the code generated
+// by binstructgen don't see the registries, so they still use the default encoding
+// of T: implement Marshaler on T if it must round-trip, and Unmarshaler for binstructgen.
+func RegisterType[T any](fn func(r Reader) (T, error)) {
+	RegisterTypeIn(globalRegistry, fn)
+}
+
+// RegisterFuncIn is the same as RegisterFunc, but registers fn in reg.
+func RegisterFuncIn[T any](reg *Registry, name string, fn func(r Reader) (T, error)) {
+	if fn == nil {
+		panic("binstruct: RegisterFunc of nil func")
+	}
+
+	reg.funcs.Store(name, newDecodeFunc(fn))
+}
+
+// RegisterTypeIn is the same as RegisterType, but registers fn in reg.
+func RegisterTypeIn[T any](reg *Registry, fn func(r Reader) (T, error)) {
+	if fn == nil {
+		panic("binstruct: RegisterType of nil func")
+	}
+
+	f := newDecodeFunc(fn)
+	if _, loaded := reg.types.Swap(f.Type, f); !loaded {
+		reg.numTypes.Add(1)
+	}
+}
+
+// decodeFunc is the registered function with the type erased.
+type decodeFunc struct {
+	Type   reflect.Type
+	Decode func(r Reader, v reflect.Value) error
+}
+
+func newDecodeFunc[T any](fn func(r Reader) (T, error)) *decodeFunc {
+	return &decodeFunc{
+		Type: reflect.TypeOf((*T)(nil)).Elem(),
+		Decode: func(r Reader, v reflect.Value) error {
+			x, err := fn(r)
+			if err != nil {
+				return err
+			}
+
+			if v.CanSet() {
+				v.Set(reflect.ValueOf(&x).Elem())
+			}
+			return nil
+		},
+	}
+}
+
+// registryOf returns the registry of the reader, or nil if it has none.
+func registryOf(r Reader) *Registry {
+	if rr, ok := r.(*reader); ok {
+		return rr.registry
+	}
+
+	return nil
+}
+
+// lookupFunc returns the function registered by name in the registry of the reader or globally.
+func lookupFunc(r Reader, name string) (*decodeFunc, bool) {
+	for _, reg := range [...]*Registry{registryOf(r), globalRegistry} {
+		if reg == nil {
+			continue
+		}
+
+		if f, ok := reg.funcs.Load(name); ok {
+			return f.(*decodeFunc), true
+		}
+	}
+
+	return nil, false
+}
+
+// lookupType returns the decoder of type t registered in the registry of the reader or globally.
+func lookupType(r Reader, t reflect.Type) (*decodeFunc, bool) {
+	for _, reg := range [...]*Registry{registryOf(r), globalRegistry} {
+		if reg == nil || reg.numTypes.Load() == 0 {
+			continue
+		}
+
+		if f, ok := reg.types.Load(t); ok {
+			return f.(*decodeFunc), true
+		}
+	}
+
+	return nil, false
+}
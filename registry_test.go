@@ -0,0 +1,139 @@
+package binstruct
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type registryDate struct {
+	Year, Month, Day int
+}
+
+// readRegistryDate reads the date in MS-DOS format.
+func readRegistryDate(r Reader) (registryDate, error) {
+	v, err := r.ReadUint16()
+	if err != nil {
+		return registryDate{}, err
+	}
+
+	return registryDate{Year: int(v>>9) + 1980, Month: int(v>>5) & 0x0f, Day: int(v) & 0x1f}, nil
+}
+
+func readRegistryIPv4(r Reader) (string, error) {
+	_, b, err := r.ReadBytes(4)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d.%d.%d.%d", b[0], b[1], b[2], b[3]), nil
+}
+
+func init() {
+	RegisterType(readRegistryDate)
+	RegisterFunc("RegistryIPv4", readRegistryIPv4)
+}
+
+func Test_Registry(t *testing.T) {
+	type dataStruct struct {
+		Date    registryDate
+		DateLE  registryDate    `bin:"le"`
+		Dates   [2]registryDate `bin:"size:5"`
+		Addr    string          `bin:"RegistryIPv4"`
+		skipped registryDate
+	}
+
+	data := []byte{
+		0x52, 0x21, // 2021-01-01
+		0x21, 0x52, // 2021-01-01 little-endian
+		0x52, 0x42, 0x52, 0x43, 0x00, // 2021-02-02, 2021-02-03 and padding
+		0x7f, 0x00, 0x00, 0x01, // 127.0.0.1
+		0x52, 0x21, // read and discarded
+	}
+
+	want := dataStruct{
+		Date:   registryDate{Year: 2021, Month: 1, Day: 1},
+		DateLE: registryDate{Year: 2021, Month: 1, Day: 1},
+		Dates:  [2]registryDate{{Year: 2021, Month: 2, Day: 2}, {Year: 2021, Month: 2, Day: 3}},
+		Addr:   "127.0.0.1",
+	}
+
+	var actual dataStruct
+	err := UnmarshalBE(data, &actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	var date registryDate
+	err = UnmarshalBE([]byte{0x52, 0x21}, &date)
+	require.NoError(t, err)
+	require.Equal(t, registryDate{Year: 2021, Month: 1, Day: 1}, date)
+}
+
+func Test_RegistryDecoder(t *testing.T) {
+	reg := NewRegistry()
+	// Days since 1980-01-01 are enough for the test.
+	RegisterTypeIn(reg, func(r Reader) (registryDate, error) {
+		v, err := r.ReadUint8()
+		return registryDate{Year: 1980, Month: 1, Day: int(v) + 1}, err
+	})
+	RegisterFuncIn(reg, "RegistryHex", func(r Reader) (string, error) {
+		_, b, err := r.ReadBytes(2)
+		return fmt.Sprintf("%x", b), err
+	})
+
+	type dataStruct struct {
+		Date  registryDate
+		Dates []registryDate `bin:"len:2, size:3"`
+		Hex   string         `bin:"be, RegistryHex"`
+		Addr  string         `bin:"RegistryIPv4"`
+	}
+
+	data := []byte{0x04, 0x00, 0x01, 0x00, 0xca, 0xfe, 0x0a, 0x00, 0x00, 0x01}
+
+	want := dataStruct{
+		Date:  registryDate{Year: 1980, Month: 1, Day: 5},
+		Dates: []registryDate{{Year: 1980, Month: 1, Day: 1}, {Year: 1980, Month: 1, Day: 2}},
+		Hex:   "cafe",
+		Addr:  "10.0.0.1",
+	}
+
+	dec := NewDecoder(bytes.NewReader(data), binary.LittleEndian)
+	dec.SetRegistry(reg)
+
+	var actual dataStruct
+	err := dec.Decode(&actual)
+	require.NoError(t, err)
+	require.Equal(t, want, actual)
+
+	// The function is registered only for the decoder.
+	type dataHex struct {
+		Hex string `bin:"RegistryHex"`
+	}
+
+	var actualHex dataHex
+	err = UnmarshalBE([]byte{0xca, 0xfe}, &actualHex)
+	require.Error(t, err)
+}
+
+func Test_RegistryErrors(t *testing.T) {
+	type dataType struct {
+		Addr []byte `bin:"RegistryIPv4"`
+	}
+
+	var actualType dataType
+	err := UnmarshalBE([]byte{0x7f, 0x00, 0x00, 0x01}, &actualType)
+	require.EqualError(t, err, `failed set value to field "Addr": `+
+		`call registered func(RegistryIPv4): function returns string, but the field is []uint8`)
+
+	type dataArgs struct {
+		Addr string `bin:"RegistryIPv4(4)"`
+	}
+
+	var actualArgs dataArgs
+	err = UnmarshalBE([]byte{0x7f, 0x00, 0x00, 0x01}, &actualArgs)
+	require.EqualError(t, err, `failed set value to field "Addr": `+
+		`call registered func(RegistryIPv4): registered functions take no arguments`)
+}
@@ -3,286 +3,764 @@ package binstruct
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
+
+	"github.com/ghostiam/binstruct/internal/bintag"
 )
 
+// Handling of duplicate keys of maps.
 const (
-	tagName = "bin"
+	dupKeyError = "error"
+	dupKeyFirst = "first"
+	dupKeyLast  = "last"
 )
 
+// Types of the length prefix.
 const (
-	tagTypeEmpty   = ""
-	tagTypeIgnore  = "-"
-	tagTypeFunc    = "func"
-	tagTypeElement = "elem"
-
-	tagTypeOrderLE = "le"
-	tagTypeOrderBE = "be"
-
-	tagTypeLength            = "len"
-	tagTypeOffsetFromCurrent = "offset"
-	tagTypeOffsetFromStart   = "offsetStart"
-	tagTypeOffsetFromEnd     = "offsetEnd"
-	tagTypeOffsetRestore     = "offsetRestore"
+	prefixUint8   = "uint8"
+	prefixUint16  = "uint16"
+	prefixUint32  = "uint32"
+	prefixUint64  = "uint64"
+	prefixUvarint = "uvarint"
+	prefixVarint  = "varint"
+	prefixZigzag  = "zigzag"
+	prefixVLQ     = "vlq"
 )
 
-type tag struct {
-	Type  string
-	Value string
+type fieldOffset struct {
+	Offset int64
+	Whence int
+}
 
-	ElemTags []tag
+// fieldTags are the values of the tags, which don't depend on the struct value.
+// They are shared by the plan and the read data, and must be copied to be changed.
+type fieldTags struct {
+	Ignore        bool
+	OffsetRestore bool
+	FuncName      string
+	WriteFuncName string
+	Order         binary.ByteOrder
+	Term          *uint64   // terminator of strings and slices of bytes, or the sentinel element
+	Until         *tagValue // condition on the fields of the sentinel element
+	KeepTerm      bool      // keep the terminator or the sentinel element in the value
+	Prefix        string    // type of the length prefix of strings and slices
+	Rest          bool      // read strings and slices until the end of the input
+	BitOrder      string    // msb or lsb, the order of bits of the bit field
+	Varint        string    // encoding of the variable-length integer
+	DupKey        string    // handling of duplicate map keys, error by default
 }
 
-func parseTag(t string) ([]tag, error) {
-	var tags []tag
+type fieldReadData struct {
+	*fieldTags
 
-	for {
-		var v string
+	Skip          bool // the condition of the if tag is false
+	Length        *int64
+	Offsets       []fieldOffset
+	FuncArgs      []int64 // arguments of the read method
+	WriteFuncArgs []int64 // arguments of the write method
+	Max           *int64  // max number of bytes read with the terminator
+	Size          *int64  // number of bytes the field occupies
+	Bits          *int64  // number of bits of the bit field
 
-		index := strings.Index(t, ",")
-		switch {
-		case index == -1:
-			v = t
-		default:
-			v = t[:index]
-			t = t[index+1:]
-		}
+	ElemFieldData *fieldReadData // if type Element
+
+	KeyFieldData   *fieldReadData // tags of map keys
+	ValueFieldData *fieldReadData // tags of map values
+
+	// If element tags refer to the element index, ElemFieldData is nil
+	// and the element data is calculated for each element by elemData.
+	ElemPlan *fieldPlan
+	ElemEnv  *exprEnv
+}
 
-		v = strings.TrimSpace(v)
+// noFieldData is the read data of the field without tags.
+var noFieldData = &fieldReadData{fieldTags: &fieldTags{}}
 
-		switch {
-		case v == tagTypeEmpty:
-			// Just skip
+// fieldPlan is the compiled form of the field tags. Values that refer to
+// other fields are calculated for each struct value by readData.
+type fieldPlan struct {
+	fieldTags
 
-		case v == tagTypeIgnore:
-			tags = append(tags, tag{Type: tagTypeIgnore})
+	If            *tagValue
+	Length        *tagValue
+	Offsets       []offsetPlan
+	FuncArgs      []*tagValue
+	WriteFuncArgs []*tagValue
+	Max           *tagValue
+	Size          *tagValue
+	Bits          *tagValue
 
-		case v == tagTypeOffsetRestore:
-			tags = append(tags, tag{Type: tagTypeOffsetRestore})
+	Elem *fieldPlan // if type Element
 
-		case strings.HasPrefix(v, "["):
-			v = v + "," + t
-			var arrBalance int
-			var closeIndex int
-			for {
-				in := v[closeIndex:]
-				idx := strings.IndexAny(in, "[]")
-				closeIndex += idx
+	Key   *fieldPlan
+	Value *fieldPlan
 
-				if idx == -1 {
-					return nil, errors.New("unbalanced square bracket")
-				}
+	static      *fieldReadData // if no value refers to other fields
+	elemIndex   bool           // if element tags refer to the element index
+	lengthToEnd bool           // if the len tag refers to size() or remaining()
+	restTags    *fieldTags     // the tags with rest, to write the len tag with size() or remaining()
+}
 
-				switch in[idx] {
-				case '[':
-					arrBalance--
-				case ']':
-					arrBalance++
-				}
+type offsetPlan struct {
+	Offset *tagValue
+	Whence int
+}
 
-				closeIndex++
+// compileFieldPlan compiles the tags of the field of type fieldType, which is nil if unknown.
+func compileFieldPlan(structType, fieldType reflect.Type, tags []bintag.Tag) (*fieldPlan, error) {
+	var p fieldPlan
+	for _, t := range tags {
+		// Values of these tags are expressions.
+		var value *tagValue
+		switch t.Type {
+		case bintag.TypeLength, bintag.TypeSize, bintag.TypeBits, bintag.TypeIf, bintag.TypeMax,
+			bintag.TypeOffsetFromCurrent, bintag.TypeOffsetFromStart, bintag.TypeOffsetFromEnd:
+			if t.Type == bintag.TypeLength && strings.TrimSpace(t.Value) == "*" {
+				break
+			}
 
-				if arrBalance == 0 {
-					break
-				}
+			var err error
+			value, err = compileValue(structType, t.Value)
+			if err != nil {
+				return nil, err
 			}
+		}
+
+		switch t.Type {
+		case bintag.TypeIgnore:
+			p = fieldPlan{fieldTags: fieldTags{Ignore: true}}
+			p.static = &fieldReadData{fieldTags: &p.fieldTags}
+			return &p, nil
+
+		case bintag.TypeLength:
+			if strings.TrimSpace(t.Value) == "*" {
+				p.Rest = true
+				break
+			}
+
+			p.Length = value
+
+		case bintag.TypeRest:
+			p.Rest = true
+
+		case bintag.TypeSize:
+			p.Size = value
 
-			t = v[closeIndex:]
-			v = v[1 : closeIndex-1]
+		case bintag.TypeBits:
+			p.Bits = value
 
-			pt, err := parseTag(v)
+		case bintag.TypeBitsMSB, bintag.TypeBitsLSB:
+			p.BitOrder = t.Type
+
+		case bintag.TypeUvarint, bintag.TypeVarint, bintag.TypeZigzag, bintag.TypeVLQ:
+			p.Varint = t.Type
+
+		case bintag.TypeIf:
+			p.If = value
+
+		case bintag.TypeOffsetFromCurrent:
+			p.Offsets = append(p.Offsets, offsetPlan{
+				Offset: value,
+				Whence: io.SeekCurrent,
+			})
+
+		case bintag.TypeOffsetFromStart:
+			p.Offsets = append(p.Offsets, offsetPlan{
+				Offset: value,
+				Whence: io.SeekStart,
+			})
+
+		case bintag.TypeOffsetFromEnd:
+			p.Offsets = append(p.Offsets, offsetPlan{
+				Offset: value,
+				Whence: io.SeekEnd,
+			})
+
+		case bintag.TypeOffsetRestore:
+			p.OffsetRestore = true
+
+		case bintag.TypeFunc:
+			name, args, err := compileMethodCall(structType, t.Value)
 			if err != nil {
 				return nil, err
 			}
+			p.FuncName, p.FuncArgs = name, args
 
-			tags = append(tags, tag{Type: tagTypeElement, ElemTags: pt})
+		case bintag.TypeWrite:
+			name, args, err := compileMethodCall(structType, t.Value)
+			if err != nil {
+				return nil, err
+			}
+			p.WriteFuncName, p.WriteFuncArgs = name, args
 
-		case v == tagTypeOrderLE:
-			tags = append(tags, tag{Type: tagTypeOrderLE})
+		case bintag.TypeCString:
+			var term uint64
+			p.Term = &term
 
-		case v == tagTypeOrderBE:
-			tags = append(tags, tag{Type: tagTypeOrderBE})
+		case bintag.TypeTerm:
+			term, err := parseTerm(t.Value)
+			if err != nil {
+				return nil, err
+			}
+			p.Term = &term
+
+		case bintag.TypeUntil:
+			until, err := compileValue(elemTypeOf(fieldType), t.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid until: %w", err)
+			}
+			p.Until = until
+
+		case bintag.TypeKeepTerm:
+			p.KeepTerm = true
+
+		case bintag.TypeMax:
+			p.Max = value
+
+		case bintag.TypePrefix:
+			prefix := strings.TrimSpace(t.Value)
+			switch prefix {
+			case prefixUint8, prefixUint16, prefixUint32, prefixUint64,
+				prefixUvarint, prefixVarint, prefixZigzag, prefixVLQ:
+				p.Prefix = prefix
+			default:
+				return nil, fmt.Errorf(
+					`invalid prefix "%s": must be uint8, uint16, uint32, uint64, uvarint, varint, zigzag or vlq`, t.Value)
+			}
+
+		case bintag.TypeElement:
+			elem, err := compileFieldPlan(structType, elemTypeOf(fieldType), t.ElemTags)
+			if err != nil {
+				return nil, err
+			}
+			p.Elem = elem
 
-		default:
-			ts := strings.Split(v, ":")
+		case bintag.TypeKey:
+			key, err := compileFieldPlan(structType, keyTypeOf(fieldType), t.ElemTags)
+			if err != nil {
+				return nil, err
+			}
+			p.Key = key
 
-			if len(ts) == 2 {
-				tags = append(tags, tag{
-					Type:  ts[0],
-					Value: ts[1],
-				})
-			} else {
-				tags = append(tags, tag{
-					Type:  tagTypeFunc,
-					Value: v,
-				})
+		case bintag.TypeValue:
+			value, err := compileFieldPlan(structType, elemTypeOf(fieldType), t.ElemTags)
+			if err != nil {
+				return nil, err
+			}
+			p.Value = value
+
+		case bintag.TypeDupKey:
+			dupKey := strings.TrimSpace(t.Value)
+			switch dupKey {
+			case dupKeyError, dupKeyFirst, dupKeyLast:
+				p.DupKey = dupKey
+			default:
+				return nil, fmt.Errorf(`invalid dupKey "%s": must be error, first or last`, t.Value)
 			}
+
+		case bintag.TypeOrderLE:
+			p.Order = binary.LittleEndian
+
+		case bintag.TypeOrderBE:
+			p.Order = binary.BigEndian
 		}
+	}
+
+	if p.Bits != nil && p.hasByteTags() {
+		return nil, errors.New("bits can't be used with other tags, except lsb and msb")
+	}
 
-		if index == -1 {
-			return tags, nil
+	for _, elem := range []*fieldPlan{p.Elem, p.Key, p.Value} {
+		if elem != nil && elem.BitOrder != "" {
+			return nil, errors.New("lsb and msb are supported only for bit fields")
 		}
 	}
+
+	if p.Varint != "" && p.Length != nil {
+		return nil, fmt.Errorf("%s can't be used with len", p.Varint)
+	}
+
+	if p.Elem != nil {
+		p.elemIndex = p.Elem.usesIndex()
+	}
+
+	p.lengthToEnd = p.Length != nil && p.Length.usesEnd()
+	if p.lengthToEnd {
+		tags := p.fieldTags
+		tags.Rest = true
+		p.restTags = &tags
+	}
+
+	if p.isStatic() {
+		var err error
+		p.static, err = p.readData(&exprEnv{}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &p, nil
 }
 
-type fieldOffset struct {
-	Offset int64
-	Whence int
+// hasByteTags reports whether any tag, that applies only to byte-aligned fields, is set.
+func (p *fieldPlan) hasByteTags() bool {
+	return p.Length != nil || len(p.Offsets) > 0 || p.OffsetRestore ||
+		p.FuncName != "" || p.WriteFuncName != "" || p.Order != nil ||
+		p.Term != nil || p.Until != nil || p.Max != nil ||
+		p.Prefix != "" || p.Rest || p.Size != nil || p.Elem != nil || p.Varint != "" ||
+		p.Key != nil || p.Value != nil || p.DupKey != ""
 }
 
-type fieldReadData struct {
-	Ignore        bool
-	Length        *int64
-	Offsets       []fieldOffset
-	OffsetRestore bool
-	FuncName      string
-	Order         binary.ByteOrder
+// values returns the values of the tags, that are set.
+func (p *fieldPlan) values() []*tagValue {
+	var values []*tagValue
+	for _, v := range []*tagValue{p.If, p.Length, p.Max, p.Size, p.Bits} {
+		if v != nil {
+			values = append(values, v)
+		}
+	}
 
-	ElemFieldData *fieldReadData // if type Element
+	for _, o := range p.Offsets {
+		values = append(values, o.Offset)
+	}
+
+	values = append(values, p.FuncArgs...)
+	values = append(values, p.WriteFuncArgs...)
+
+	return values
 }
 
-func parseCalc(v string) (nums, ops []string) {
-	cur := v
-	for {
-		idx := strings.IndexAny(cur, "+-/*")
-		if idx == -1 {
-			nums = append(nums, cur)
-			break
+func (p *fieldPlan) isStatic() bool {
+	for _, v := range p.values() {
+		if !v.isConst() {
+			return false
 		}
+	}
 
-		nums = append(nums, cur[:idx])
-		ops = append(ops, string(cur[idx]))
+	for _, elem := range []*fieldPlan{p.Elem, p.Key, p.Value} {
+		if elem != nil && elem.static == nil {
+			return false
+		}
+	}
 
-		cur = cur[idx+1:]
+	return true
+}
+
+// usesIndex reports whether any value refers to the element index.
+func (p *fieldPlan) usesIndex() bool {
+	for _, v := range p.values() {
+		if v.usesIndex() {
+			return true
+		}
 	}
 
-	return nums, ops
+	return false
 }
 
-func parseValue(structValue reflect.Value, v string) (int64, error) {
-	v = strings.TrimSpace(v)
-	if v == "" {
-		return 0, nil
+// skippedFieldData is the read data of the field, whose if tag is false.
+var skippedFieldData = &fieldReadData{fieldTags: &fieldTags{}, Skip: true}
+
+// fieldDataBuffer is the storage of the field read data, which is reused for
+// the fields of the struct, so that the values are not allocated for each field.
+// The read data is valid until the next field.
+type fieldDataBuffer struct {
+	data   fieldReadData
+	values [4]int64 // Length, Max, Size and Bits
+}
+
+// structState is the environment of the tag expressions of the struct and the buffer
+// of the read data of its fields, they are allocated together once for the struct value.
+type structState struct {
+	env exprEnv
+	buf fieldDataBuffer
+}
+
+// readData calculates the field read data for the struct value and its parents.
+// The data is stored to buf, or to the newly allocated one if buf is nil.
+func (p *fieldPlan) readData(env *exprEnv, buf *fieldDataBuffer) (*fieldReadData, error) {
+	if p.static != nil {
+		return p.static, nil
 	}
 
-	// calculate
-	mathIndex := strings.IndexAny(v, "+-/*")
-	if mathIndex != -1 {
-		nums, ops := parseCalc(v)
+	if p.If != nil {
+		ok, err := p.If.eval(env)
+		if err != nil {
+			return nil, err
+		}
 
-		var result int64
-		for k := range nums {
-			n, err := parseValue(structValue, nums[k])
-			if err != nil {
-				return 0, err
-			}
+		if ok == 0 {
+			return skippedFieldData, nil
+		}
+	}
 
-			if k == 0 {
-				result = n
-				continue
-			}
+	if buf == nil {
+		buf = &fieldDataBuffer{}
+	}
 
-			switch ops[k-1] {
-			case "+":
-				result += n
-			case "-":
-				result -= n
-			case "/":
-				result /= n
-			case "*":
-				result *= n
-			}
+	data := &buf.data
+	*data = fieldReadData{
+		fieldTags: &p.fieldTags,
+		Offsets:   buf.data.Offsets[:0],
+	}
+
+	var err error
+	if p.lengthToEnd && env.Writing {
+		// The end of the output is unknown, the actual length is written as with rest.
+		data.fieldTags = p.restTags
+	} else {
+		data.Length, err = evalTo(env, p.Length, &buf.values[0])
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		return result, nil
+	data.Max, err = evalTo(env, p.Max, &buf.values[1])
+	if err != nil {
+		return nil, err
 	}
 
-	// parse value or get from field
-	l, err := strconv.ParseInt(v, 10, 0)
+	data.Size, err = evalTo(env, p.Size, &buf.values[2])
 	if err != nil {
-		sv := structValue
+		return nil, err
+	}
 
-		split := strings.Split(v, ".")
-		for _, s := range split {
-			sv = sv.FieldByName(s)
-			if sv.Kind() != reflect.Struct {
-				break
-			}
+	data.Bits, err = evalTo(env, p.Bits, &buf.values[3])
+	if err != nil {
+		return nil, err
+	}
+
+	data.FuncArgs, err = evalValues(env, p.FuncArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	data.WriteFuncArgs, err = evalValues(env, p.WriteFuncArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range p.Offsets {
+		offset, err := o.Offset.eval(env)
+		if err != nil {
+			return nil, err
 		}
 
-		lenVal := sv
-		switch lenVal.Kind() {
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			l = lenVal.Int()
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-			l = int64(lenVal.Uint())
-		default:
-			return 0, errors.New("can't get field len from \"" + v + "\" field")
+		data.Offsets = append(data.Offsets, fieldOffset{
+			Offset: offset,
+			Whence: o.Whence,
+		})
+	}
+
+	switch {
+	case p.elemIndex:
+		data.ElemPlan, data.ElemEnv = p.Elem, env
+	case p.Elem != nil:
+		data.ElemFieldData, err = p.Elem.readData(env, nil)
+		if err != nil {
+			return nil, err
 		}
 	}
-	return l, nil
+
+	if p.Key != nil {
+		data.KeyFieldData, err = p.Key.readData(env, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Value != nil {
+		data.ValueFieldData, err = p.Value.readData(env, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
 }
 
-func parseReadDataFromTags(structValue reflect.Value, tags []tag) (*fieldReadData, error) {
-	var data fieldReadData
+// evalTo evaluates the value to v, it returns nil if the value is not set.
+func evalTo(env *exprEnv, value *tagValue, v *int64) (*int64, error) {
+	if value == nil {
+		return nil, nil
+	}
+
 	var err error
-	for _, t := range tags {
-		switch t.Type {
-		case tagTypeIgnore:
-			return &fieldReadData{Ignore: true}, nil
-
-		case tagTypeLength:
-			var length int64
-			length, err = parseValue(structValue, t.Value)
-			data.Length = &length
-
-		case tagTypeOffsetFromCurrent:
-			var offset int64
-			offset, err = parseValue(structValue, t.Value)
-			data.Offsets = append(data.Offsets, fieldOffset{
-				Offset: offset,
-				Whence: io.SeekCurrent,
-			})
+	*v, err = value.eval(env)
+	if err != nil {
+		return nil, err
+	}
 
-		case tagTypeOffsetFromStart:
-			var offset int64
-			offset, err = parseValue(structValue, t.Value)
-			data.Offsets = append(data.Offsets, fieldOffset{
-				Offset: offset,
-				Whence: io.SeekStart,
-			})
+	return v, nil
+}
 
-		case tagTypeOffsetFromEnd:
-			var offset int64
-			offset, err = parseValue(structValue, t.Value)
-			data.Offsets = append(data.Offsets, fieldOffset{
-				Offset: offset,
-				Whence: io.SeekEnd,
-			})
+// elemData returns the read data of the element with index i.
+func (d *fieldReadData) elemData(i int) (*fieldReadData, error) {
+	if d.ElemPlan == nil {
+		return d.ElemFieldData, nil
+	}
+
+	env := *d.ElemEnv
+	env.Index, env.HasIndex = int64(i), true
+	data, err := d.ElemPlan.readData(&env, nil)
+	if err != nil {
+		return nil, fmt.Errorf("element %d: %w", i, err)
+	}
+
+	return data, nil
+}
+
+// termByte returns the terminator of strings and slices of bytes.
+func (d *fieldReadData) termByte() (byte, error) {
+	if *d.Term > math.MaxUint8 {
+		return 0, fmt.Errorf("term 0x%x is greater than a byte", *d.Term)
+	}
+
+	return byte(*d.Term), nil
+}
+
+// parseTerm parses the terminator value, negative values are stored as two's complement.
+func parseTerm(v string) (uint64, error) {
+	v = strings.TrimSpace(v)
+
+	u, err := strconv.ParseUint(v, 0, 64)
+	if err == nil {
+		return u, nil
+	}
+
+	i, err := strconv.ParseInt(v, 0, 64)
+	if err == nil {
+		return uint64(i), nil
+	}
+
+	return 0, fmt.Errorf(`invalid term "%s"`, v)
+}
+
+// isSentinelTerminated reports whether the slice elements are terminated by the sentinel element.
+func (d *fieldReadData) isSentinelTerminated() bool {
+	return d.Term != nil || d.Until != nil
+}
+
+// sizedData returns the field read data for the value inside the region of the size tag.
+// Strings and slices without the length are read until the end of the region,
+// the zero padding at the end of such strings is trimmed by setSizedValueToField.
+func (d *fieldReadData) sizedData(t reflect.Type) *fieldReadData {
+	tags := *d.fieldTags
+	tags.OffsetRestore = false
+
+	data := *d
+	data.fieldTags = &tags
+	data.Size = nil
+	data.Offsets = nil
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if kind := t.Kind(); kind == reflect.String || kind == reflect.Slice {
+		if data.Length == nil && data.Prefix == "" && !data.isSentinelTerminated() {
+			tags.Rest = true
+		}
+	}
 
-		case tagTypeOffsetRestore:
-			data.OffsetRestore = true
+	return &data
+}
 
-		case tagTypeFunc:
-			data.FuncName = t.Value
+// pointeeData returns the field read data for the value the pointer field points to,
+// which is read at the position of the pointer field.
+func (d *fieldReadData) pointeeData() *fieldReadData {
+	data := *d
+	data.Offsets = nil
+	if d.OffsetRestore {
+		tags := *d.fieldTags
+		tags.OffsetRestore = false
+		data.fieldTags = &tags
+	}
 
-		case tagTypeElement:
-			data.ElemFieldData, err = parseReadDataFromTags(structValue, t.ElemTags)
+	return &data
+}
 
-		case tagTypeOrderLE:
-			data.Order = binary.LittleEndian
+// elemTypeOf returns the type of elements of the slice, array or map t, or nil.
+func elemTypeOf(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
 
-		case tagTypeOrderBE:
-			data.Order = binary.BigEndian
+	if t != nil {
+		switch t.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return t.Elem()
 		}
+	}
+
+	return nil
+}
+
+// keyTypeOf returns the type of keys of the map t, or nil.
+func keyTypeOf(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
 
+	if t != nil && t.Kind() == reflect.Map {
+		return t.Key()
+	}
+
+	return nil
+}
+
+// sentinel matches the element that terminates the slice,
+// by the term tag for integers or by the until tag for structs.
+type sentinel struct {
+	term  *uint64
+	mask  uint64
+	until *tagValue
+	env   exprEnv // environment of the until tag, the struct is the element
+}
+
+// newSentinel returns the sentinel of the slice field of the struct, parents are
+// the parent structs of the struct.
+func newSentinel(
+	elemType reflect.Type, fieldData *fieldReadData, structValue reflect.Value, parentStructValues []reflect.Value,
+) (*sentinel, error) {
+	s := &sentinel{term: fieldData.Term, until: fieldData.Until}
+
+	if s.until != nil {
+		if elemType.Kind() != reflect.Struct {
+			return nil, errors.New("until is supported only for slices of structs")
+		}
+
+		parents := make([]reflect.Value, len(parentStructValues), len(parentStructValues)+1)
+		copy(parents, parentStructValues)
+		s.env.Parents = append(parents, structValue)
+		return s, nil
+	}
+
+	if !isIntegerKind(elemType.Kind()) {
+		return nil, errors.New("term is supported only for slices of integers")
+	}
+
+	s.mask = math.MaxUint64 >> (64 - 8*elemType.Size())
+	return s, nil
+}
+
+func (s *sentinel) match(v reflect.Value) (bool, error) {
+	if s.until != nil {
+		s.env.Struct = v
+		ok, err := s.until.eval(&s.env)
 		if err != nil {
-			return nil, err
+			return false, fmt.Errorf("until: %w", err)
+		}
+
+		return ok != 0, nil
+	}
+
+	var value uint64
+	if v.CanInt() {
+		value = uint64(v.Int())
+	} else {
+		value = v.Uint()
+	}
+
+	return value&s.mask == *s.term&s.mask, nil
+}
+
+// value returns the sentinel element, which is written after the elements.
+// The element can be made only for the until tag in the form of "Field == Value".
+func (s *sentinel) value(elemType reflect.Type) (reflect.Value, error) {
+	elem := reflect.New(elemType).Elem()
+
+	if s.term != nil {
+		if elem.CanInt() {
+			elem.SetInt(int64(*s.term))
+		} else {
+			elem.SetUint(*s.term)
+		}
+
+		return elem, nil
+	}
+
+	cannotMake := fmt.Errorf(`cannot make the sentinel element for until "%s", use keepTerm`, s.until.Raw)
+
+	b, ok := s.until.Root.(*exprBinary)
+	if !ok || b.Op != "==" {
+		return reflect.Value{}, cannotMake
+	}
+
+	field, ok := b.X.(*exprField)
+	other := b.Y
+	if !ok {
+		field, ok = b.Y.(*exprField)
+		other = b.X
+	}
+	if !ok || !field.isLocal() || field.Index == nil || len(field.Elem) > 0 || !isConstNode(other) {
+		return reflect.Value{}, cannotMake
+	}
+
+	term, err := other.eval(&exprEnv{})
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	v, err := fieldByIndex(elem, field.Index)
+	if err != nil || !isIntegerKind(v.Kind()) {
+		return reflect.Value{}, cannotMake
+	}
+
+	if v.CanInt() {
+		v.SetInt(term)
+	} else {
+		v.SetUint(uint64(term))
+	}
+
+	// The value can overflow the field.
+	if ok, err := s.match(elem); err != nil || !ok {
+		return reflect.Value{}, cannotMake
+	}
+
+	return elem, nil
+}
+
+func isIntegerKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+// fieldIndexByName returns the index sequence of the field found by name,
+// the names of nested struct fields are separated by a dot.
+func fieldIndexByName(structType reflect.Type, name string) []int {
+	var index []int
+
+	t := structType
+	for _, s := range strings.Split(name, ".") {
+		if t == nil || t.Kind() != reflect.Struct {
+			return nil
+		}
+
+		f, ok := t.FieldByName(s)
+		if !ok {
+			return nil
+		}
+
+		index = append(index, f.Index...)
+
+		t = f.Type
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			break
 		}
 	}
 
-	return &data, nil
+	return index
 }
@@ -4,131 +4,14 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/ghostiam/binstruct/internal/bintag"
 	"github.com/stretchr/testify/require"
 )
 
-func Test_parseTag(t *testing.T) {
-	tests := []struct {
-		name    string
-		tag     string
-		want    []tag
-		wantErr string
-	}{
-		{
-			name: "empty",
-			tag:  "",
-			want: nil,
-		},
-		{
-			name: "ignore",
-			tag:  "-",
-			want: []tag{{Type: "-"}},
-		},
-		{
-			name: "le",
-			tag:  "le",
-			want: []tag{{Type: "le"}},
-		},
-		{
-			name: "be",
-			tag:  "be",
-			want: []tag{{Type: "be"}},
-		},
-		{
-			name: "func",
-			tag:  "TestFunc",
-			want: []tag{{Type: "func", Value: "TestFunc"}},
-		},
-		{
-			name: "element ignore",
-			tag:  "[-]",
-			want: []tag{{Type: "elem", ElemTags: []tag{{Type: "-"}}}},
-		},
-		{
-			name: "len",
-			tag:  "len:42",
-			want: []tag{{Type: "len", Value: "42"}},
-		},
-		{
-			name: "offset",
-			tag:  "offset:42",
-			want: []tag{{Type: "offset", Value: "42"}},
-		},
-		{
-			name: "offsetStart",
-			tag:  "offsetStart:42",
-			want: []tag{{Type: "offsetStart", Value: "42"}},
-		},
-		{
-			name: "offsetEnd",
-			tag:  "offsetEnd:42",
-			want: []tag{{Type: "offsetEnd", Value: "42"}},
-		},
-		{
-			name: "multi tag",
-			tag:  "len:1, offset:2, offsetStart:3, offsetEnd:4, offsetRestore",
-			want: []tag{
-				{Type: "len", Value: "1"},
-				{Type: "offset", Value: "2"},
-				{Type: "offsetStart", Value: "3"},
-				{Type: "offsetEnd", Value: "4"},
-				{Type: "offsetRestore"},
-			},
-		},
-		{
-			name: "multi element",
-			tag:  "[len:1, [len:2, [len3]]], offset:42",
-			want: []tag{
-				{
-					Type: "elem", Value: "", ElemTags: []tag{
-						{
-							Type: "len", Value: "1", ElemTags: []tag(nil),
-						},
-						{
-							Type: "elem", Value: "", ElemTags: []tag{
-								{
-									Type: "len", Value: "2", ElemTags: []tag(nil),
-								},
-								{
-									Type: "elem", Value: "", ElemTags: []tag{
-										{
-											Type: "func", Value: "len3", ElemTags: []tag(nil),
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-				{
-					Type: "offset", Value: "42", ElemTags: []tag(nil),
-				},
-			},
-		},
-		{
-			name:    "unbalanced",
-			tag:     "[",
-			wantErr: "unbalanced square bracket",
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := parseTag(tt.tag)
-			if tt.wantErr != "" {
-				require.EqualError(t, err, tt.wantErr)
-				return
-			}
-
-			require.NoError(t, err)
-			require.Equal(t, tt.want, got)
-		})
-	}
-}
-
-func Test_parseReadDataFromTags(t *testing.T) {
+func Test_compileFieldPlan(t *testing.T) {
 	type args struct {
 		structValue reflect.Value
-		tags        []tag
+		tags        []bintag.Tag
 	}
 	ptrInt := func(i int64) *int64 {
 		return &i
@@ -142,7 +25,7 @@ func Test_parseReadDataFromTags(t *testing.T) {
 			name: "calc len 5+2",
 			args: args{
 				structValue: reflect.ValueOf(struct{}{}),
-				tags: []tag{
+				tags: []bintag.Tag{
 					{
 						Type:  "len",
 						Value: "5+2",
@@ -150,14 +33,15 @@ func Test_parseReadDataFromTags(t *testing.T) {
 				},
 			},
 			want: &fieldReadData{
-				Length: ptrInt(7),
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(7),
 			},
 		},
 		{
 			name: "calc len 1-2",
 			args: args{
 				structValue: reflect.ValueOf(struct{}{}),
-				tags: []tag{
+				tags: []bintag.Tag{
 					{
 						Type:  "len",
 						Value: "5-2",
@@ -165,14 +49,15 @@ func Test_parseReadDataFromTags(t *testing.T) {
 				},
 			},
 			want: &fieldReadData{
-				Length: ptrInt(3),
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(3),
 			},
 		},
 		{
 			name: "calc len 5*2",
 			args: args{
 				structValue: reflect.ValueOf(struct{}{}),
-				tags: []tag{
+				tags: []bintag.Tag{
 					{
 						Type:  "len",
 						Value: "5*2",
@@ -180,14 +65,15 @@ func Test_parseReadDataFromTags(t *testing.T) {
 				},
 			},
 			want: &fieldReadData{
-				Length: ptrInt(10),
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(10),
 			},
 		},
 		{
 			name: "calc len 10/2",
 			args: args{
 				structValue: reflect.ValueOf(struct{}{}),
-				tags: []tag{
+				tags: []bintag.Tag{
 					{
 						Type:  "len",
 						Value: "10/2",
@@ -195,7 +81,8 @@ func Test_parseReadDataFromTags(t *testing.T) {
 				},
 			},
 			want: &fieldReadData{
-				Length: ptrInt(5),
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(5),
 			},
 		},
 		{
@@ -206,7 +93,7 @@ func Test_parseReadDataFromTags(t *testing.T) {
 				}{
 					FieldValue: 2,
 				}),
-				tags: []tag{
+				tags: []bintag.Tag{
 					{
 						Type:  "len",
 						Value: "5+FieldValue",
@@ -214,7 +101,8 @@ func Test_parseReadDataFromTags(t *testing.T) {
 				},
 			},
 			want: &fieldReadData{
-				Length: ptrInt(7),
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(7),
 			},
 		},
 		{
@@ -225,7 +113,7 @@ func Test_parseReadDataFromTags(t *testing.T) {
 				}{
 					FieldValue: 2,
 				}),
-				tags: []tag{
+				tags: []bintag.Tag{
 					{
 						Type:  "len",
 						Value: "5-FieldValue",
@@ -233,7 +121,64 @@ func Test_parseReadDataFromTags(t *testing.T) {
 				},
 			},
 			want: &fieldReadData{
-				Length: ptrInt(3),
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(3),
+			},
+		},
+		{
+			name: "calc len hex 0x10 | 0b1 & Field",
+			args: args{
+				structValue: reflect.ValueOf(struct {
+					Field uint8
+				}{
+					Field: 0x0f,
+				}),
+				tags: []bintag.Tag{
+					{
+						Type:  "len",
+						Value: "0x10 | 0b1 & Field",
+					},
+				},
+			},
+			want: &fieldReadData{
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(0x11),
+			},
+		},
+		{
+			name: "calc len (2+2)*2 + 1<<4 % 3",
+			args: args{
+				structValue: reflect.ValueOf(struct{}{}),
+				tags: []bintag.Tag{
+					{
+						Type:  "len",
+						Value: "(2+2)*2 + 1<<4 % 3",
+					},
+				},
+			},
+			want: &fieldReadData{
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(9),
+			},
+		},
+		{
+			name: "calc len -(Field - 10) + (Field > 2 && Field != 5)",
+			args: args{
+				structValue: reflect.ValueOf(struct {
+					Field int8
+				}{
+					Field: 4,
+				}),
+				tags: []bintag.Tag{
+					{
+						Type:  "len",
+						Value: "-(Field - 10) + (Field > 2 && Field != 5)",
+					},
+				},
+			},
+			want: &fieldReadData{
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(7),
 			},
 		},
 		{
@@ -246,7 +191,7 @@ func Test_parseReadDataFromTags(t *testing.T) {
 					FieldAdd: 5,
 					FieldSub: 10,
 				}),
-				tags: []tag{
+				tags: []bintag.Tag{
 					{
 						Type:  "len",
 						Value: "10 + FieldAdd + 10 - 5 - FieldSub / 2",
@@ -254,14 +199,75 @@ func Test_parseReadDataFromTags(t *testing.T) {
 				},
 			},
 			want: &fieldReadData{
-				Length: ptrInt(5),
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(15),
+			},
+		},
+		{
+			name: "calc len Values[1] + Items[Index].Value",
+			args: args{
+				structValue: reflect.ValueOf(struct {
+					Index  int
+					Values []uint16
+					Items  [2]struct{ Value int8 }
+				}{
+					Index:  1,
+					Values: []uint16{1, 2},
+					Items:  [2]struct{ Value int8 }{{Value: 10}, {Value: 20}},
+				}),
+				tags: []bintag.Tag{
+					{
+						Type:  "len",
+						Value: "Values[1] + Items[Index].Value",
+					},
+				},
+			},
+			want: &fieldReadData{
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(22),
+			},
+		},
+		{
+			name: "calc len align(offsetof(C), 4) + max(1, 2, -3)",
+			args: args{
+				structValue: reflect.ValueOf(struct {
+					A uint8
+					B [3]uint16
+					C uint32
+				}{}),
+				tags: []bintag.Tag{
+					{
+						Type:  "len",
+						Value: "align(offsetof(C), 4) + max(1, 2, -3)",
+					},
+				},
+			},
+			want: &fieldReadData{
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(10),
+			},
+		},
+		{
+			name: "calc len 010 is decimal",
+			args: args{
+				structValue: reflect.ValueOf(struct{}{}),
+				tags: []bintag.Tag{
+					{
+						Type:  "len",
+						Value: "010 + 0o10",
+					},
+				},
+			},
+			want: &fieldReadData{
+				fieldTags: &fieldTags{},
+				Length:    ptrInt(18),
 			},
 		},
 		{
 			name: "calc offset -10",
 			args: args{
 				structValue: reflect.ValueOf(struct{}{}),
-				tags: []tag{
+				tags: []bintag.Tag{
 					{
 						Type:  "offset",
 						Value: "-10",
@@ -269,6 +275,7 @@ func Test_parseReadDataFromTags(t *testing.T) {
 				},
 			},
 			want: &fieldReadData{
+				fieldTags: &fieldTags{},
 				Offsets: []fieldOffset{
 					{
 						Offset: -10,
@@ -281,7 +288,7 @@ func Test_parseReadDataFromTags(t *testing.T) {
 			name: "calc offset -10 + -5",
 			args: args{
 				structValue: reflect.ValueOf(struct{}{}),
-				tags: []tag{
+				tags: []bintag.Tag{
 					{
 						Type:  "offset",
 						Value: "-10 + -5",
@@ -289,6 +296,7 @@ func Test_parseReadDataFromTags(t *testing.T) {
 				},
 			},
 			want: &fieldReadData{
+				fieldTags: &fieldTags{},
 				Offsets: []fieldOffset{
 					{
 						Offset: -15,
@@ -301,7 +309,7 @@ func Test_parseReadDataFromTags(t *testing.T) {
 			name: "calc offset -10 + -5 + 5",
 			args: args{
 				structValue: reflect.ValueOf(struct{}{}),
-				tags: []tag{
+				tags: []bintag.Tag{
 					{
 						Type:  "offset",
 						Value: "-10 + -5 + 5",
@@ -309,6 +317,7 @@ func Test_parseReadDataFromTags(t *testing.T) {
 				},
 			},
 			want: &fieldReadData{
+				fieldTags: &fieldTags{},
 				Offsets: []fieldOffset{
 					{
 						Offset: -10,
@@ -320,9 +329,97 @@ func Test_parseReadDataFromTags(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := parseReadDataFromTags(tt.args.structValue, tt.args.tags)
+			p, err := compileFieldPlan(tt.args.structValue.Type(), nil, tt.args.tags)
+			require.NoError(t, err)
+
+			got, err := p.readData(&exprEnv{Struct: tt.args.structValue}, nil)
 			require.NoError(t, err)
 			require.Equal(t, tt.want, got)
 		})
 	}
 }
+
+func Test_compileFieldPlanErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{
+			name:    "unbalanced parentheses",
+			value:   "(Field+2",
+			wantErr: `invalid expression "(Field+2": unexpected end`,
+		},
+		{
+			name:    "unexpected operator",
+			value:   "Field + * 2",
+			wantErr: `invalid expression "Field + * 2": unexpected "*" at 8`,
+		},
+		{
+			name:    "invalid number",
+			value:   "0x1G",
+			wantErr: `invalid expression "0x1G": invalid number "0x1G" at 0`,
+		},
+		{
+			name:    "unexpected character",
+			value:   "Field # 2",
+			wantErr: `invalid expression "Field # 2": unexpected "#" at 6`,
+		},
+		{
+			name:    "unclosed index",
+			value:   "Field[1",
+			wantErr: `invalid expression "Field[1": unexpected end`,
+		},
+		{
+			name:    "division by zero",
+			value:   "10 / (Field - 4)",
+			wantErr: `division by zero in "10 / (Field - 4)"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			structValue := reflect.ValueOf(struct{ Field int }{Field: 4})
+			p, err := compileFieldPlan(structValue.Type(), nil, []bintag.Tag{{Type: "len", Value: tt.value}})
+			if err == nil {
+				_, err = p.readData(&exprEnv{Struct: structValue}, nil)
+			}
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func Test_parseConstErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		wantErr string
+	}{
+		{
+			name: "division by zero",
+			value: &struct {
+				Data []byte `bin:"len:4/0"`
+			}{},
+			wantErr: `failed parseTag for field "Data": division by zero in "4/0"`,
+		},
+		{
+			name: "negative shift",
+			value: &struct {
+				Data []byte `bin:"len:1<<-1"`
+			}{},
+			wantErr: `failed parseTag for field "Data": negative shift count -1`,
+		},
+		{
+			name: "align to zero",
+			value: &struct {
+				Data []byte `bin:"[len:align(3, 0)]"`
+			}{},
+			wantErr: `failed parseTag for field "Data": align 0 is not positive`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := UnmarshalBE([]byte{0x01}, tt.value)
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -1,17 +1,38 @@
 package binstruct
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"strconv"
 	"strings"
+
+	"github.com/ghostiam/binstruct/internal/bintag"
 )
 
 type unmarshal struct {
 	r Reader
 }
 
+// Unmarshaler is the interface implemented by types that can decode
+// themselves. UnmarshalBinstruct is called instead of the default decoding
+// for any value of the type, including struct fields and slice elements.
+//
+// The method must not call Unmarshal on the same type, as it will be
+// called again recursively.
+type Unmarshaler interface {
+	UnmarshalBinstruct(r Reader) error
+}
+
+var (
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	readerType      = reflect.TypeOf((*Reader)(nil)).Elem()
+	errorType       = reflect.TypeOf((*error)(nil)).Elem()
+)
+
 // An InvalidUnmarshalError describes an invalid argument passed to Unmarshal.
 // (The argument to Unmarshal must be a non-nil pointer.)
 type InvalidUnmarshalError struct {
@@ -30,35 +51,51 @@ func (e *InvalidUnmarshalError) Error() string {
 }
 
 func (u *unmarshal) Unmarshal(v interface{}) error {
-	return u.unmarshal(v, nil)
-}
-
-func (u *unmarshal) unmarshal(v interface{}, parentStructValues []reflect.Value) error {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
 		return &InvalidUnmarshalError{reflect.TypeOf(v)}
 	}
 
-	structValue := rv.Elem()
-	numField := structValue.NumField()
+	if f, ok := lookupType(u.r, rv.Elem().Type()); ok {
+		return f.Decode(u.r, rv.Elem())
+	}
 
-	valueType := structValue.Type()
-	for i := 0; i < numField; i++ {
-		fieldType := valueType.Field(i)
-		tags, err := parseTag(fieldType.Tag.Get(tagName))
-		if err != nil {
-			return fmt.Errorf(`failed parseTag for field "%s": %w`, fieldType.Name, err)
-		}
+	if um, ok := v.(Unmarshaler); ok {
+		return um.UnmarshalBinstruct(u.r)
+	}
+
+	return u.unmarshal(rv.Elem(), nil)
+}
 
-		fieldData, err := parseReadDataFromTags(structValue, tags)
+func (u *unmarshal) unmarshal(structValue reflect.Value, parentStructValues []reflect.Value) error {
+	plan, err := getStructPlan(structValue.Type())
+	if err != nil {
+		return err
+	}
+
+	state := &structState{env: exprEnv{Struct: structValue, Parents: parentStructValues, Stream: u.r}}
+
+	// Adjacent bit fields share bytes, any other field starts at the byte boundary.
+	bits := bitReader{order: plan.BitOrder}
+
+	for _, field := range plan.Fields {
+		fieldData, err := field.readData(&state.env, &state.buf)
 		if err != nil {
-			return fmt.Errorf(`failed parse ReadData from tags for field "%s": %w`, fieldType.Name, err)
+			return fmt.Errorf(`failed parse ReadData from tags for field "%s": %w`, field.Name, err)
 		}
 
-		fieldValue := structValue.Field(i)
-		err = u.setValueToField(structValue, fieldValue, fieldData, parentStructValues)
+		fieldValue := structValue.Field(field.Index)
+		if fieldData.Bits != nil {
+			err = bits.setValueToField(u.r, fieldValue, fieldData)
+		} else {
+			if !fieldData.Ignore && !fieldData.Skip {
+				bits.align()
+			}
+
+			err = u.setValueToField(structValue, fieldValue, fieldData, parentStructValues)
+		}
 		if err != nil {
-			return fmt.Errorf(`failed set value to field "%s": %w`, fieldType.Name, err)
+			return fmt.Errorf(`failed set value to field "%s": %w`, field.Name, err)
 		}
 	}
 
@@ -69,13 +106,20 @@ func (u *unmarshal) setValueToField(
 	structValue, fieldValue reflect.Value, fieldData *fieldReadData, parentStructValues []reflect.Value,
 ) error {
 	if fieldData == nil {
-		fieldData = &fieldReadData{}
+		fieldData = noFieldData
 	}
 
 	if fieldData.Ignore {
 		return nil
 	}
 
+	if fieldData.Skip {
+		if fieldValue.CanSet() {
+			fieldValue.Set(reflect.Zero(fieldValue.Type()))
+		}
+		return nil
+	}
+
 	r := u.r
 	if fieldData.Order != nil {
 		r = r.WithOrder(fieldData.Order)
@@ -94,9 +138,13 @@ func (u *unmarshal) setValueToField(
 		return fmt.Errorf("set offset: %w", err)
 	}
 
+	if fieldData.Size != nil {
+		return u.setSizedValueToField(structValue, fieldValue, fieldData, parentStructValues)
+	}
+
 	if fieldData.FuncName != "" {
 		var okCallFunc bool
-		okCallFunc, err = callFunc(r, fieldData.FuncName, structValue, fieldValue)
+		okCallFunc, err = callFunc(r, fieldData.FuncName, fieldData.FuncArgs, structValue, fieldValue)
 		if err != nil {
 			return fmt.Errorf("call custom func(%s): %w", structValue.Type().Name(), err)
 		}
@@ -105,7 +153,7 @@ func (u *unmarshal) setValueToField(
 			// Try call function from parent structs
 			for i := len(parentStructValues) - 1; i >= 0; i-- {
 				sv := parentStructValues[i]
-				okCallFunc, err = callFunc(r, fieldData.FuncName, sv, fieldValue)
+				okCallFunc, err = callFunc(r, fieldData.FuncName, fieldData.FuncArgs, sv, fieldValue)
 				if err != nil {
 					return fmt.Errorf("call custom func from parent(%s): %w", sv.Type().Name(), err)
 				}
@@ -115,15 +163,26 @@ func (u *unmarshal) setValueToField(
 				}
 			}
 
+			// Try call the registered function
+			if f, ok := lookupFunc(r, fieldData.FuncName); ok {
+				err = callRegisteredFunc(r, f, fieldData, fieldValue)
+				if err != nil {
+					return fmt.Errorf("call registered func(%s): %w", fieldData.FuncName, err)
+				}
+
+				return nil
+			}
+
 			message := `
 failed call method, expected methods:
-	func (*{{Struct}}) {{MethodName}}(r binstruct.Reader) error {} 
+	func (*{{Struct}}) {{MethodName}}(r binstruct.Reader{{Params}}) error {} 
 or
-	func (*{{Struct}}) {{MethodName}}(r binstruct.Reader) ({{FieldType}}, error) {}
+	func (*{{Struct}}) {{MethodName}}(r binstruct.Reader{{Params}}) ({{FieldType}}, error) {}
 `
 			message = strings.NewReplacer(
 				`{{Struct}}`, structValue.Type().Name(),
 				`{{MethodName}}`, fieldData.FuncName,
+				`{{Params}}`, methodParams(len(fieldData.FuncArgs)),
 				`{{FieldType}}`, fieldValue.Type().String(),
 			).Replace(message)
 			return errors.New(message)
@@ -132,6 +191,62 @@ or
 		return nil
 	}
 
+	if f, ok := lookupType(r, fieldValue.Type()); ok {
+		return f.Decode(r, fieldValue)
+	}
+
+	if fieldValue.Kind() == reflect.Ptr {
+		// The value is decoded to the newly allocated one, so the pointer field is nil when it is skipped.
+		value := reflect.New(fieldValue.Type().Elem())
+		err := u.setValueToField(structValue, value.Elem(), fieldData.pointeeData(), parentStructValues)
+		if err != nil {
+			return err
+		}
+
+		if fieldValue.CanSet() {
+			fieldValue.Set(value)
+		}
+		return nil
+	}
+
+	if isUnmarshaler(fieldValue.Type()) {
+		value := fieldValue
+		if !value.CanSet() {
+			// Unexported and blank fields are read, but the value is discarded.
+			value = reflect.New(fieldValue.Type()).Elem()
+		}
+
+		return value.Addr().Interface().(Unmarshaler).UnmarshalBinstruct(r)
+	}
+
+	if fieldData.Bits != nil {
+		return errors.New("bits is supported only for struct fields")
+	}
+
+	if fieldData.Varint != "" {
+		return setVarintValueToField(r, fieldValue, fieldData.Varint)
+	}
+
+	if fieldData.Rest && fieldValue.Kind() != reflect.String && fieldValue.Kind() != reflect.Slice {
+		return errors.New("rest is supported only for strings and slices")
+	}
+
+	if fieldData.Prefix != "" {
+		if fieldValue.Kind() != reflect.String && fieldValue.Kind() != reflect.Slice {
+			return errors.New("prefix is supported only for strings and slices")
+		}
+
+		length, err := readPrefix(r, fieldData.Prefix)
+		if err != nil {
+			return fmt.Errorf("read prefix: %w", err)
+		}
+
+		// The prefix is used as len tag.
+		data := *fieldData
+		data.Length = &length
+		fieldData = &data
+	}
+
 	switch fieldValue.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		var value int64
@@ -233,11 +348,18 @@ or
 			fieldValue.SetBool(b)
 		}
 	case reflect.String:
-		if fieldData.Length == nil {
+		var b []byte
+		switch {
+		case fieldData.Term != nil:
+			b, err = readTerminated(r, fieldData)
+		case fieldData.Rest:
+			b, err = r.ReadAll()
+		case fieldData.Length != nil:
+			_, b, err = r.ReadBytes(int(*fieldData.Length))
+		default:
 			return errors.New("need set tag with len for string")
 		}
 
-		_, b, err := r.ReadBytes(int(*fieldData.Length))
 		if err != nil {
 			return err
 		}
@@ -246,11 +368,67 @@ or
 			fieldValue.SetString(string(b))
 		}
 	case reflect.Slice:
+		if fieldData.Term != nil && fieldValue.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := readTerminated(u.r, fieldData)
+			if err != nil {
+				return err
+			}
+
+			if fieldValue.CanSet() {
+				fieldValue.SetBytes(b)
+			}
+
+			return nil
+		}
+
+		if fieldData.Rest {
+			// If slice of bytes, read all bytes and set to slice.
+			if fieldValue.Type().Elem().Kind() == reflect.Uint8 {
+				b, err := u.r.ReadAll()
+				if err != nil {
+					return err
+				}
+
+				if fieldValue.CanSet() {
+					fieldValue.SetBytes(b)
+				}
+
+				return nil
+			}
+
+			if fieldValue.CanSet() {
+				fieldValue.Set(reflect.MakeSlice(fieldValue.Type(), 0, 0))
+			}
+
+			return u.setRestValueToField(structValue, fieldValue, fieldData, parentStructValues)
+		}
+
+		if fieldData.isSentinelTerminated() {
+			// The len tag is the max number of elements, if set.
+			// It is not preallocated, as the max can be much greater than the actual length.
+			arrLen := -1
+			if fieldData.Length != nil {
+				arrLen = int(*fieldData.Length)
+				if arrLen < 0 {
+					return ErrNegativeCount
+				}
+			}
+
+			if fieldValue.CanSet() {
+				fieldValue.Set(reflect.MakeSlice(fieldValue.Type(), 0, 0))
+			}
+
+			return u.setArrayValueToField(arrLen, structValue, fieldValue, fieldData, parentStructValues)
+		}
+
 		if fieldData.Length == nil {
 			return errors.New("need set tag with len for slice")
 		}
 
 		arrLen := int(*fieldData.Length)
+		if arrLen < 0 {
+			return ErrNegativeCount
+		}
 
 		// If slice of bytes, read bytes and set to slice.
 		if fieldValue.Type().Elem().Kind() == reflect.Uint8 {
@@ -271,8 +449,9 @@ or
 		}
 
 		if fieldValue.CanSet() {
-			// Create slice before populate.
-			fieldValue.Set(reflect.MakeSlice(fieldValue.Type(), arrLen, arrLen))
+			// The count comes from the input, so only a small slice is preallocated,
+			// it grows as elements are read.
+			fieldValue.Set(reflect.MakeSlice(fieldValue.Type(), 0, min(arrLen, maxSliceSizeHint)))
 		}
 
 		return u.setArrayValueToField(arrLen, structValue, fieldValue, fieldData, parentStructValues)
@@ -282,12 +461,26 @@ or
 
 		if fieldData.Length != nil {
 			arrLen = int(*fieldData.Length)
+			if arrLen < 0 {
+				return ErrNegativeCount
+			}
+
+			if arrLen > fieldValue.Len() {
+				return fmt.Errorf("len %d is greater than array len %d", arrLen, fieldValue.Len())
+			}
 		}
 
 		return u.setArrayValueToField(arrLen, structValue, fieldValue, fieldData, parentStructValues)
 
+	case reflect.Map:
+		if fieldData.Length == nil {
+			return errors.New("need set tag with len for map")
+		}
+
+		return u.setMapValueToField(int(*fieldData.Length), structValue, fieldValue, fieldData, parentStructValues)
+
 	case reflect.Struct:
-		err = u.unmarshal(fieldValue.Addr().Interface(), append(parentStructValues, structValue))
+		err = u.unmarshal(fieldValue, append(parentStructValues, structValue))
 		if err != nil {
 			return fmt.Errorf("unmarshal struct: %w", err)
 		}
@@ -298,61 +491,479 @@ or
 	return nil
 }
 
+// setArrayValueToField reads arrLen elements. If the elements are terminated by the sentinel element,
+// reading stops at the sentinel, and arrLen is the max number of elements, or unlimited if negative.
+// Elements of the slice are appended.
 func (u *unmarshal) setArrayValueToField(
 	arrLen int, structValue, fieldValue reflect.Value, fieldData *fieldReadData, parentStructValues []reflect.Value,
 ) error {
-	for i := 0; i < arrLen; i++ {
+	var sentinel *sentinel
+	if fieldData.isSentinelTerminated() && fieldValue.Kind() == reflect.Slice {
+		var err error
+		sentinel, err = newSentinel(fieldValue.Type().Elem(), fieldData, structValue, parentStructValues)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; arrLen < 0 || i < arrLen; i++ {
+		elemData, err := fieldData.elemData(i)
+		if err != nil {
+			return err
+		}
+
+		tmpV := reflect.New(fieldValue.Type().Elem()).Elem()
+		err = u.setValueToField(structValue, tmpV, elemData, parentStructValues)
+		if err != nil {
+			return err
+		}
+
+		var isSentinel bool
+		if sentinel != nil {
+			isSentinel, err = sentinel.match(tmpV)
+			if err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+		if isSentinel && !fieldData.KeepTerm {
+			return nil
+		}
+
+		if fieldValue.CanSet() {
+			if fieldValue.Kind() == reflect.Slice {
+				fieldValue.Set(reflect.Append(fieldValue, tmpV))
+			} else {
+				fieldValue.Index(i).Set(tmpV)
+			}
+		}
+
+		if isSentinel {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// maxSliceSizeHint is the max number of slice elements preallocated before reading.
+const maxSliceSizeHint = 1024
+
+// maxMapSizeHint is the max number of map pairs preallocated before reading.
+const maxMapSizeHint = 64
+
+// setMapValueToField reads mapLen pairs of the key and the value.
+// Duplicate keys are an error, unless the dupKey tag keeps the first or the last value.
+func (u *unmarshal) setMapValueToField(
+	mapLen int, structValue, fieldValue reflect.Value, fieldData *fieldReadData, parentStructValues []reflect.Value,
+) error {
+	if mapLen < 0 {
+		return ErrNegativeCount
+	}
+
+	// The count comes from the input, so only a small map is preallocated, it grows as pairs are read.
+	t := fieldValue.Type()
+	m := reflect.MakeMapWithSize(t, min(mapLen, maxMapSizeHint))
+
+	for i := 0; i < mapLen; i++ {
+		key := reflect.New(t.Key()).Elem()
+		err := u.setValueToField(structValue, key, fieldData.KeyFieldData, parentStructValues)
+		if err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+
+		value := reflect.New(t.Elem()).Elem()
+		err = u.setValueToField(structValue, value, fieldData.ValueFieldData, parentStructValues)
+		if err != nil {
+			return fmt.Errorf("value %d: %w", i, err)
+		}
+
+		if m.MapIndex(key).IsValid() {
+			switch fieldData.DupKey {
+			case dupKeyFirst:
+				continue
+			case dupKeyLast:
+			default:
+				return fmt.Errorf("duplicate key %v", key)
+			}
+		}
+
+		m.SetMapIndex(key, value)
+	}
+
+	if fieldValue.CanSet() {
+		fieldValue.Set(m)
+	}
+
+	return nil
+}
+
+// setRestValueToField appends elements to the slice until the end of the input.
+// The input must end at the element boundary, otherwise io.ErrUnexpectedEOF is returned.
+func (u *unmarshal) setRestValueToField(
+	structValue, fieldValue reflect.Value, fieldData *fieldReadData, parentStructValues []reflect.Value,
+) error {
+	for i := 0; ; i++ {
+		_, err := u.r.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		start, err := u.r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("get current offset: %w", err)
+		}
+
+		elemData, err := fieldData.elemData(i)
+		if err != nil {
+			return err
+		}
+
 		tmpV := reflect.New(fieldValue.Type().Elem()).Elem()
-		err := u.setValueToField(structValue, tmpV, fieldData.ElemFieldData, parentStructValues)
+		err = u.setValueToField(structValue, tmpV, elemData, parentStructValues)
 		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("element %d: %w", i, io.ErrUnexpectedEOF)
+			}
 			return err
 		}
+
+		end, err := u.r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("get current offset: %w", err)
+		}
+
+		if end == start {
+			return fmt.Errorf("element %d: no bytes read", i)
+		}
+
 		if fieldValue.CanSet() {
-			fieldValue.Index(i).Set(tmpV)
+			fieldValue.Set(reflect.Append(fieldValue, tmpV))
+		}
+	}
+}
+
+// setSizedValueToField reads the field from the region of the size tag bytes.
+// Reading past the end of the region returns io.EOF, the unread rest of the region is skipped.
+func (u *unmarshal) setSizedValueToField(
+	structValue, fieldValue reflect.Value, fieldData *fieldReadData, parentStructValues []reflect.Value,
+) error {
+	size := *fieldData.Size
+	if size < 0 {
+		return ErrNegativeCount
+	}
+
+	start, err := u.r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("get current offset: %w", err)
+	}
+
+	r, err := limitReader(u.r, size)
+	if err != nil {
+		return err
+	}
+
+	ru := &unmarshal{r: r}
+	data := fieldData.sizedData(fieldValue.Type())
+	err = ru.setValueToField(structValue, fieldValue, data, parentStructValues)
+	if err != nil {
+		return err
+	}
+
+	// The string read until the end of the region includes the zero padding written by marshal.
+	if data.Rest && !fieldData.Rest {
+		v := fieldValue
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+
+		if v.Kind() == reflect.String && v.CanSet() {
+			v.SetString(strings.TrimRight(v.String(), "\x00"))
 		}
 	}
 
+	_, err = u.r.Seek(start+size, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("skip rest of size: %w", err)
+	}
+
 	return nil
 }
 
-func callFunc(r Reader, funcName string, structValue, fieldValue reflect.Value) (bool, error) {
+// callFunc calls the read method of the struct. Arguments of the tag are passed
+// to the integer parameters after the reader.
+func callFunc(r Reader, funcName string, args []int64, structValue, fieldValue reflect.Value) (bool, error) {
+	// Methods of unexported struct fields can't be called
+	if !structValue.CanAddr() || !structValue.CanInterface() {
+		return false, nil
+	}
+
 	// Call methods
-	m := structValue.Addr().MethodByName(funcName)
+	method, ok := methodByName(structValue.Type(), funcName)
+	if !ok {
+		return false, nil
+	}
+
+	mt := method.Type // the receiver is the first argument
+	if mt.NumIn() < 2 || mt.In(1) != readerType {
+		return false, nil
+	}
+
+	var withValue bool
+	switch {
+	// Method(r binstruct.Reader, args...) error
+	case mt.NumOut() == 1 && mt.Out(0) == errorType:
+
+	// Method(r binstruct.Reader, args...) (FieldType, error)
+	case mt.NumOut() == 2 && mt.Out(0) == fieldValue.Type() && mt.Out(1) == errorType:
+		withValue = true
+
+	default:
+		return false, nil
+	}
+
+	switch {
+	case mt.NumIn()-2 == len(args):
+	case len(args) == 0:
+		return false, nil
+	default:
+		return true, fmt.Errorf("%s: %d arguments in the tag, but the method has %d parameters after the reader",
+			funcName, len(args), mt.NumIn()-2)
+	}
+
+	in, err := methodArgs(mt, 2, args)
+	if err != nil {
+		return true, fmt.Errorf("%s: %w", funcName, err)
+	}
+
+	ret := structValue.Addr().Method(method.Index).Call(append([]reflect.Value{reflect.ValueOf(r)}, in...))
+	if errValue := ret[len(ret)-1]; !errValue.IsNil() {
+		return true, errValue.Interface().(error)
+	}
+
+	if withValue && fieldValue.CanSet() {
+		fieldValue.Set(ret[0])
+	}
+	return true, nil
+}
+
+// callRegisteredFunc calls the function registered by the name of the tag.
+func callRegisteredFunc(r Reader, f *decodeFunc, fieldData *fieldReadData, fieldValue reflect.Value) error {
+	if len(fieldData.FuncArgs) > 0 {
+		return errors.New("registered functions take no arguments")
+	}
+
+	if !f.Type.AssignableTo(fieldValue.Type()) {
+		return fmt.Errorf("function returns %s, but the field is %s", f.Type, fieldValue.Type())
+	}
+
+	return f.Decode(r, fieldValue)
+}
+
+// methodParams returns the integer parameters of the method with n arguments for the error message.
+func methodParams(n int) string {
+	if n == 0 {
+		return ""
+	}
+
+	names := make([]string, n)
+	for i := range names {
+		names[i] = "a" + strconv.Itoa(i+1)
+	}
+
+	return ", " + strings.Join(names, ", ") + " int"
+}
+
+// methodArgs converts the arguments of the tag to the integer parameters of the method,
+// starting from the parameter in.
+func methodArgs(mt reflect.Type, in int, args []int64) ([]reflect.Value, error) {
+	values := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		t := mt.In(in + i)
+		v := reflect.New(t).Elem()
+
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.OverflowInt(arg) {
+				return nil, fmt.Errorf("argument %d: value %d overflows %s", i+1, arg, t)
+			}
+			v.SetInt(arg)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if arg < 0 || v.OverflowUint(uint64(arg)) {
+				return nil, fmt.Errorf("argument %d: value %d overflows %s", i+1, arg, t)
+			}
+			v.SetUint(uint64(arg))
+		default:
+			return nil, fmt.Errorf("argument %d: parameter of type %s is not an integer", i+1, t)
+		}
+
+		values[i] = v
+	}
+
+	return values, nil
+}
+
+// readPrefix reads the length prefix of the type from the tag.
+func readPrefix(r Reader, prefix string) (int64, error) {
+	var length uint64
+	var err error
+
+	switch prefix {
+	case prefixUint8:
+		var v uint8
+		v, err = r.ReadUint8()
+		length = uint64(v)
+	case prefixUint16:
+		var v uint16
+		v, err = r.ReadUint16()
+		length = uint64(v)
+	case prefixUint32:
+		var v uint32
+		v, err = r.ReadUint32()
+		length = uint64(v)
+	case prefixUint64:
+		length, err = r.ReadUint64()
+	case prefixUvarint:
+		length, err = r.ReadUvarint()
+	case prefixVLQ:
+		length, err = r.ReadVLQ()
+	case prefixVarint, prefixZigzag:
+		var v int64
+		if prefix == prefixVarint {
+			v, err = r.ReadVarint()
+		} else {
+			v, err = r.ReadZigzag()
+		}
+
+		if err == nil && v < 0 {
+			return 0, ErrNegativeCount
+		}
+		length = uint64(v)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if length > math.MaxInt {
+		return 0, fmt.Errorf("len %d is too large", length)
+	}
+
+	return int64(length), nil
+}
+
+// setVarintValueToField reads the variable-length integer with the encoding from the tag.
+func setVarintValueToField(r Reader, fieldValue reflect.Value, varint string) error {
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return fmt.Errorf("%s is supported only for integers", varint)
+	}
 
-	readerType := reflect.TypeOf((*Reader)(nil)).Elem()
-	if m.IsValid() && m.Type().NumIn() == 1 && m.Type().In(0) == readerType {
-		ret := m.Call([]reflect.Value{reflect.ValueOf(r)})
+	var u uint64
+	var i int64
+	var err error
+
+	signed := varint == bintag.TypeVarint || varint == bintag.TypeZigzag
+	switch varint {
+	case bintag.TypeUvarint:
+		u, err = r.ReadUvarint()
+	case bintag.TypeVLQ:
+		u, err = r.ReadVLQ()
+	case bintag.TypeVarint:
+		i, err = r.ReadVarint()
+	case bintag.TypeZigzag:
+		i, err = r.ReadZigzag()
+	}
 
-		errorType := reflect.TypeOf((*error)(nil)).Elem()
+	if err != nil {
+		return err
+	}
 
-		// Method(r binstruct.Reader) error
-		if len(ret) == 1 && ret[0].Type() == errorType {
-			if !ret[0].IsNil() {
-				return true, ret[0].Interface().(error)
+	switch fieldValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !signed {
+			if u > math.MaxInt64 {
+				return fmt.Errorf("value %d overflows %s", u, fieldValue.Type())
 			}
+			i = int64(u)
+		}
 
-			return true, nil
+		if fieldValue.OverflowInt(i) {
+			return fmt.Errorf("value %d overflows %s", i, fieldValue.Type())
 		}
 
-		// Method(r binstruct.Reader) (FieldType, error)
-		if len(ret) == 2 && ret[0].Type() == fieldValue.Type() && ret[1].Type() == errorType {
-			if !ret[1].IsNil() {
-				return true, ret[1].Interface().(error)
+		if fieldValue.CanSet() {
+			fieldValue.SetInt(i)
+		}
+	default:
+		if signed {
+			if i < 0 {
+				return fmt.Errorf("value %d overflows %s", i, fieldValue.Type())
 			}
+			u = uint64(i)
+		}
 
-			if fieldValue.CanSet() {
-				fieldValue.Set(ret[0])
+		if fieldValue.OverflowUint(u) {
+			return fmt.Errorf("value %d overflows %s", u, fieldValue.Type())
+		}
+
+		if fieldValue.CanSet() {
+			fieldValue.SetUint(u)
+		}
+	}
+
+	return nil
+}
+
+// readTerminated reads the data ended by the terminator from the tag. If len is also set,
+// exactly len bytes are read and the data is cut at the first terminator.
+func readTerminated(r Reader, fieldData *fieldReadData) ([]byte, error) {
+	term, err := fieldData.termByte()
+	if err != nil {
+		return nil, err
+	}
+
+	if fieldData.Length != nil {
+		_, b, err := r.ReadBytes(int(*fieldData.Length))
+		if err != nil {
+			return nil, err
+		}
+
+		if i := bytes.IndexByte(b, term); i != -1 {
+			if fieldData.KeepTerm {
+				i++
 			}
-			return true, nil
+			b = b[:i]
 		}
+
+		return b, nil
+	}
+
+	var b []byte
+	if fieldData.Max == nil {
+		b, err = r.ReadUntil(term)
+	} else {
+		b, err = readUntil(r, term, int(*fieldData.Max))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if fieldData.KeepTerm {
+		b = append(b, term)
 	}
 
-	return false, nil
+	return b, nil
 }
 
-func setOffset(r Reader, fieldData *fieldReadData) error {
+func setOffset(s io.Seeker, fieldData *fieldReadData) error {
 	for _, v := range fieldData.Offsets {
-		_, err := r.Seek(v.Offset, v.Whence)
+		_, err := s.Seek(v.Offset, v.Whence)
 		if err != nil {
 			return fmt.Errorf("seek: %w", err)
 		}
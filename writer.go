@@ -0,0 +1,342 @@
+package binstruct
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+var (
+	// ErrNegativePosition is returned when an attempt is made to seek to a negative position
+	ErrNegativePosition = errors.New("binstruct: negative position")
+)
+
+// Writer is the interface that wraps the binstruct writer methods.
+type Writer interface {
+	io.WriteSeeker
+
+	// WriteBytes writes all bytes of b.
+	WriteBytes(b []byte) error
+
+	// WriteByte write one byte
+	WriteByte(c byte) error
+	// WriteBool write one byte with boolean value
+	WriteBool(v bool) error
+
+	// WriteUint8 write one byte with uint8 value
+	WriteUint8(v uint8) error
+	// WriteUint16 write two bytes with uint16 value
+	WriteUint16(v uint16) error
+	// WriteUint32 write four bytes with uint32 value
+	WriteUint32(v uint32) error
+	// WriteUint64 write eight bytes with uint64 value
+	WriteUint64(v uint64) error
+	// WriteUintX write X bytes with uint64 value, the value must fit in X bytes
+	WriteUintX(x int, v uint64) error
+
+	// WriteInt8 write one byte with int8 value
+	WriteInt8(v int8) error
+	// WriteInt16 write two bytes with int16 value
+	WriteInt16(v int16) error
+	// WriteInt32 write four bytes with int32 value
+	WriteInt32(v int32) error
+	// WriteInt64 write eight bytes with int64 value
+	WriteInt64(v int64) error
+	// WriteIntX write X bytes with int64 value, the value must fit in X bytes
+	WriteIntX(x int, v int64) error
+
+	// WriteFloat32 write four bytes with float32 value
+	WriteFloat32(v float32) error
+	// WriteFloat64 write eight bytes with float64 value
+	WriteFloat64(v float64) error
+
+	// WriteUvarint write the unsigned LEB128 integer
+	WriteUvarint(v uint64) error
+	// WriteVarint write the signed LEB128 integer
+	WriteVarint(v int64) error
+	// WriteZigzag write the zigzag-encoded LEB128 integer, as encoding/binary varint
+	WriteZigzag(v int64) error
+	// WriteVLQ write the big-endian variable-length quantity, as MIDI
+	WriteVLQ(v uint64) error
+
+	// Marshal writes the binary encoding of v.
+	Marshal(v interface{}) error
+
+	// WithOrder changes the byte order for the new Writer
+	WithOrder(order binary.ByteOrder) Writer
+}
+
+// NewWriter returns a new writer that writes to w with byte order.
+// If debug set true, all written bytes and offsets will be displayed.
+func NewWriter(w io.WriteSeeker, order binary.ByteOrder, debug bool) Writer {
+	return &writer{
+		w:     w,
+		order: order,
+		debug: debug,
+	}
+}
+
+// NewWriterToBytes returns a new writer that writes to *data with byte order,
+// growing the slice as needed.
+// If debug set true, all written bytes and offsets will be displayed.
+func NewWriterToBytes(data *[]byte, order binary.ByteOrder, debug bool) Writer {
+	return NewWriter(&bytesWriteSeeker{buf: data}, order, debug)
+}
+
+type writer struct {
+	w     io.WriteSeeker
+	order binary.ByteOrder
+
+	debug bool
+}
+
+func (w *writer) WriteBytes(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	var offset int64
+	if w.debug {
+		offset, _ = w.w.Seek(0, io.SeekCurrent)
+	}
+
+	n, err := w.Write(b)
+
+	if w.debug {
+		fmt.Printf("Write(offset: %d|len: %d): %s", offset, n, hex.Dump(b[:n]))
+	}
+
+	return err
+}
+
+func (w *writer) WriteByte(c byte) error {
+	return w.WriteUint8(c)
+}
+
+func (w *writer) WriteBool(v bool) error {
+	if v {
+		return w.WriteUint8(1)
+	}
+
+	return w.WriteUint8(0)
+}
+
+func (w *writer) WriteUint8(v uint8) error {
+	return w.WriteBytes([]byte{v})
+}
+
+func (w *writer) WriteUint16(v uint16) error {
+	b := make([]byte, 2)
+	w.order.PutUint16(b, v)
+	return w.WriteBytes(b)
+}
+
+func (w *writer) WriteUint32(v uint32) error {
+	b := make([]byte, 4)
+	w.order.PutUint32(b, v)
+	return w.WriteBytes(b)
+}
+
+func (w *writer) WriteUint64(v uint64) error {
+	b := make([]byte, 8)
+	w.order.PutUint64(b, v)
+	return w.WriteBytes(b)
+}
+
+func (w *writer) WriteUintX(x int, v uint64) error {
+	if x >= 0 && x < 8 {
+		n := uint(64 - 8*x)
+		if v<<n>>n != v {
+			return fmt.Errorf("value %d overflows %d bytes", v, x)
+		}
+	}
+
+	return w.writeUintX(x, v)
+}
+
+// writeUintX writes the low x bytes of v.
+func (w *writer) writeUintX(x int, v uint64) error {
+	if x > 8 {
+		return errors.New("cannot write more than 8 bytes for custom length (u)int")
+	}
+
+	if x < 0 {
+		return ErrNegativeCount
+	}
+
+	b := make([]byte, x)
+
+	switch w.order {
+	case binary.BigEndian:
+		for j := 0; j < x; j++ {
+			b[x-j-1] = byte(v >> (8 * j))
+		}
+
+	case binary.LittleEndian:
+		for j := 0; j < x; j++ {
+			b[j] = byte(v >> (8 * j))
+		}
+
+	default:
+		return errors.New("cannot determine endianness for custom (u)int length write")
+	}
+
+	return w.WriteBytes(b)
+}
+
+func (w *writer) WriteInt8(v int8) error {
+	return w.WriteUint8(uint8(v))
+}
+
+func (w *writer) WriteInt16(v int16) error {
+	return w.WriteUint16(uint16(v))
+}
+
+func (w *writer) WriteInt32(v int32) error {
+	return w.WriteUint32(uint32(v))
+}
+
+func (w *writer) WriteInt64(v int64) error {
+	return w.WriteUint64(uint64(v))
+}
+
+func (w *writer) WriteIntX(x int, v int64) error {
+	if x >= 0 && x < 8 {
+		// The value fits if the sign extension of the low x bytes is the value.
+		n := uint(64 - 8*x)
+		if v<<n>>n != v {
+			return fmt.Errorf("value %d overflows %d bytes", v, x)
+		}
+	}
+
+	return w.writeUintX(x, uint64(v))
+}
+
+func (w *writer) WriteFloat32(v float32) error {
+	return w.WriteUint32(math.Float32bits(v))
+}
+
+func (w *writer) WriteFloat64(v float64) error {
+	return w.WriteUint64(math.Float64bits(v))
+}
+
+func (w *writer) WriteUvarint(v uint64) error {
+	return w.WriteBytes(binary.AppendUvarint(nil, v))
+}
+
+func (w *writer) WriteVarint(v int64) error {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+
+		// Stop when the rest is the sign extension of the last byte.
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			b = append(b, c)
+			break
+		}
+
+		b = append(b, c|0x80)
+	}
+
+	return w.WriteBytes(b)
+}
+
+func (w *writer) WriteZigzag(v int64) error {
+	return w.WriteBytes(binary.AppendVarint(nil, v))
+}
+
+func (w *writer) WriteVLQ(v uint64) error {
+	var b [10]byte
+	i := len(b) - 1
+	b[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		b[i] = byte(v&0x7f) | 0x80
+	}
+
+	return w.WriteBytes(b[i:])
+}
+
+// io.Writer
+func (w *writer) Write(p []byte) (n int, err error) {
+	return w.w.Write(p)
+}
+
+// io.Seeker
+func (w *writer) Seek(offset int64, whence int) (int64, error) {
+	i, err := w.w.Seek(offset, whence)
+
+	if w.debug {
+		whenceStr := "invalid"
+		switch whence {
+		case io.SeekStart:
+			whenceStr = "SeekStart"
+		case io.SeekCurrent:
+			whenceStr = "SeekCurrent"
+		case io.SeekEnd:
+			whenceStr = "SeekEnd"
+		}
+
+		fmt.Printf("Seek(%d, %s) CurPos:%d\n", offset, whenceStr, i)
+	}
+
+	return i, err
+}
+
+func (w *writer) Marshal(v interface{}) error {
+	m := &marshal{w}
+	return m.Marshal(v)
+}
+
+func (w *writer) WithOrder(order binary.ByteOrder) Writer {
+	return NewWriter(w.w, order, w.debug)
+}
+
+// bytesWriteSeeker is an io.WriteSeeker over a growable byte slice.
+// Seeking past the end is allowed, the gap is filled with zeros on the next write.
+// Positions are counted from base, the position of the first byte of the slice in the stream.
+type bytesWriteSeeker struct {
+	buf  *[]byte
+	pos  int64
+	base int64
+}
+
+func (b *bytesWriteSeeker) Write(p []byte) (n int, err error) {
+	end := b.pos + int64(len(p))
+	if grow := end - int64(len(*b.buf)); grow > 0 {
+		*b.buf = append(*b.buf, make([]byte, grow)...)
+	}
+
+	copy((*b.buf)[b.pos:], p)
+	b.pos = end
+	return len(p), nil
+}
+
+func (b *bytesWriteSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = b.base + b.pos + offset
+	case io.SeekEnd:
+		abs = b.base + int64(len(*b.buf)) + offset
+	default:
+		return 0, errors.New("binstruct: invalid whence")
+	}
+
+	if abs < 0 {
+		return 0, ErrNegativePosition
+	}
+
+	if abs < b.base {
+		return 0, fmt.Errorf("binstruct: cannot seek to %d, the data before %d is already written", abs, b.base)
+	}
+
+	b.pos = abs - b.base
+	return abs, nil
+}
@@ -0,0 +1,101 @@
+package binstruct
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WriterIntX(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var data []byte
+		w := NewWriterToBytes(&data, order, false)
+		require.NoError(t, w.WriteIntX(3, -1048573))
+		require.NoError(t, w.WriteUintX(5, 1031073364746))
+		require.NoError(t, w.WriteIntX(7, 35198597301730058))
+
+		r := NewReaderFromBytes(data, order, false)
+
+		i3, err := r.ReadIntX(3)
+		require.NoError(t, err)
+		require.Equal(t, int64(-1048573), i3)
+
+		u5, err := r.ReadUintX(5)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1031073364746), u5)
+
+		i7, err := r.ReadIntX(7)
+		require.NoError(t, err)
+		require.Equal(t, int64(35198597301730058), i7)
+	}
+}
+
+func Test_WriterIntXOverflow(t *testing.T) {
+	var data []byte
+	w := NewWriterToBytes(&data, binary.BigEndian, false)
+	require.EqualError(t, w.WriteUintX(3, 0x01000000), "value 16777216 overflows 3 bytes")
+	require.EqualError(t, w.WriteIntX(1, 128), "value 128 overflows 1 bytes")
+	require.EqualError(t, w.WriteIntX(1, -129), "value -129 overflows 1 bytes")
+	require.EqualError(t, w.WriteIntX(0, 1), "value 1 overflows 0 bytes")
+
+	require.NoError(t, w.WriteUintX(3, 0xffffff))
+	require.NoError(t, w.WriteIntX(1, 127))
+	require.NoError(t, w.WriteIntX(1, -128))
+	require.NoError(t, w.WriteUintX(8, math.MaxUint64))
+	require.Equal(t, []byte{0xff, 0xff, 0xff, 0x7f, 0x80, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, data)
+}
+
+func Test_WriterUintXTooLong(t *testing.T) {
+	var data []byte
+	w := NewWriterToBytes(&data, binary.BigEndian, false)
+	require.EqualError(t, w.WriteUintX(9, 1), "cannot write more than 8 bytes for custom length (u)int")
+}
+
+func Test_WriterWithOrder(t *testing.T) {
+	var data []byte
+	w := NewWriterToBytes(&data, binary.BigEndian, false)
+	require.NoError(t, w.WriteUint16(1))
+	require.NoError(t, w.WithOrder(binary.LittleEndian).WriteUint16(1))
+	require.NoError(t, w.WriteFloat32(3.1415927))
+	require.Equal(t, []byte{0x00, 0x01, 0x01, 0x00, 0x40, 0x49, 0x0f, 0xdb}, data)
+}
+
+func Test_WriterToBytesSeek(t *testing.T) {
+	data := []byte{0x01, 0x02}
+	w := NewWriterToBytes(&data, binary.BigEndian, false)
+
+	_, err := w.Seek(4, io.SeekStart)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteByte(0x05))
+
+	_, err = w.Seek(-4, io.SeekEnd)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteBytes([]byte{0x0a, 0x0b}))
+	require.Equal(t, []byte{0x01, 0x0a, 0x0b, 0x00, 0x05}, data)
+
+	_, err = w.Seek(-1, io.SeekStart)
+	require.True(t, errors.Is(err, ErrNegativePosition))
+}
+
+func Test_WriterVarint(t *testing.T) {
+	var data []byte
+	w := NewWriterToBytes(&data, binary.BigEndian, false)
+	require.NoError(t, w.WriteUvarint(624485))
+	require.NoError(t, w.WriteVarint(-123456))
+	require.NoError(t, w.WriteVarint(64))
+	require.NoError(t, w.WriteZigzag(-64))
+	require.NoError(t, w.WriteVLQ(0x0fffffff))
+	require.NoError(t, w.WriteVLQ(0))
+	require.Equal(t, []byte{
+		0xe5, 0x8e, 0x26,
+		0xc0, 0xbb, 0x78,
+		0xc0, 0x00,
+		0x7f,
+		0xff, 0xff, 0xff, 0x7f,
+		0x00,
+	}, data)
+}